@@ -1,7 +1,10 @@
 package auctionrunner
 
 import (
+	"context"
+	"math/rand"
 	"os"
+	"sync"
 	"time"
 
 	"code.cloudfoundry.org/clock"
@@ -22,6 +25,125 @@ type auctionRunner struct {
 	workPool                      *workpool.WorkPool
 	startingContainerWeight       float64
 	startingContainerCountMaximum int
+	batchOptions                  []BatchOption
+	schedulerOptions              []SchedulerOption
+	topology                      auctiontypes.TopologyResolver
+	auctionTimeout                time.Duration // 0 does not bound auctions
+	shutdownMode                  ShutdownMode
+	retryPolicy                   *RetryPolicy
+	retrier                       *retrier
+	fetchCellRepsFailures         int
+	fetchCellRepsRNG              *rand.Rand
+
+	cancelLock     *sync.Mutex
+	auctioning     bool
+	cancelledLRPs  map[string]struct{}
+	cancelledTasks map[string]struct{}
+}
+
+// fetchCellRepsBackoff paces the auctions retried after the cell reps could
+// not be fetched.
+var fetchCellRepsBackoff = RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 30 * time.Second}
+
+type Option func(*auctionRunner)
+
+func WithBatchOptions(options ...BatchOption) Option {
+	return func(a *auctionRunner) {
+		a.batchOptions = append(a.batchOptions, options...)
+	}
+}
+
+func WithSchedulerOptions(options ...SchedulerOption) Option {
+	return func(a *auctionRunner) {
+		a.schedulerOptions = append(a.schedulerOptions, options...)
+	}
+}
+
+// WithTopologyResolver spreads LRP instances across the regions, racks and
+// hosts the resolver locates cells in, in addition to zones.
+func WithTopologyResolver(resolver auctiontypes.TopologyResolver) Option {
+	return func(a *auctionRunner) {
+		a.topology = resolver
+	}
+}
+
+// WithAuctionTimeout bounds every auction, from fetching the cell states to
+// committing the work, to timeout. Cells that do not respond in time are
+// dropped from the auction.
+func WithAuctionTimeout(timeout time.Duration) Option {
+	return func(a *auctionRunner) {
+		a.auctionTimeout = timeout
+	}
+}
+
+// WithFetchCellRepsJitter takes the jitter of the backoff after failing to
+// fetch the cell reps from rng. A nil rng is seeded from the clock. The rng
+// must not be used elsewhere while the runner runs.
+func WithFetchCellRepsJitter(rng *rand.Rand) Option {
+	return func(a *auctionRunner) {
+		a.fetchCellRepsRNG = rng
+	}
+}
+
+// auctionContext bounds parent by the auction timeout, measured on the
+// runner's clock.
+func (a *auctionRunner) auctionContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if a.auctionTimeout <= 0 {
+		return parent, func() {}
+	}
+
+	ctx := &clockTimeoutContext{
+		Context:  parent,
+		deadline: a.clock.Now().Add(a.auctionTimeout),
+		done:     make(chan struct{}),
+	}
+	timer := a.clock.NewTimer(a.auctionTimeout)
+	go func() {
+		select {
+		case <-timer.C():
+			ctx.cancel(context.DeadlineExceeded)
+		case <-parent.Done():
+			timer.Stop()
+			ctx.cancel(parent.Err())
+		case <-ctx.done:
+			timer.Stop()
+		}
+	}()
+	return ctx, func() { ctx.cancel(context.Canceled) }
+}
+
+// clockTimeoutContext is a context whose deadline is kept by a clock.Clock
+// rather than the wall clock.
+type clockTimeoutContext struct {
+	context.Context
+	deadline time.Time
+	done     chan struct{}
+	once     sync.Once
+	lock     sync.Mutex
+	err      error
+}
+
+func (c *clockTimeoutContext) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *clockTimeoutContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *clockTimeoutContext) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+func (c *clockTimeoutContext) cancel(err error) {
+	c.once.Do(func() {
+		c.lock.Lock()
+		c.err = err
+		c.lock.Unlock()
+		close(c.done)
+	})
 }
 
 func New(
@@ -32,102 +154,212 @@ func New(
 	workPool *workpool.WorkPool,
 	startingContainerWeight float64,
 	startingContainerCountMaximum int,
+	options ...Option,
 ) *auctionRunner {
-	return &auctionRunner{
+	a := &auctionRunner{
 		logger: logger,
 
 		delegate:                      delegate,
 		metricEmitter:                 metricEmitter,
-		batch:                         NewBatch(clock),
 		clock:                         clock,
 		workPool:                      workPool,
 		startingContainerWeight:       startingContainerWeight,
 		startingContainerCountMaximum: startingContainerCountMaximum,
+		cancelLock:                    &sync.Mutex{},
+		retrier:                       newRetrier(),
 	}
+	for _, option := range options {
+		option(a)
+	}
+	if a.fetchCellRepsRNG == nil {
+		a.fetchCellRepsRNG = rand.New(rand.NewSource(clock.Now().UnixNano()))
+	}
+	a.batch = NewBatch(clock, a.batchOptions...)
+	return a
 }
 
 func (a *auctionRunner) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopping := make(chan struct{})
+	go func() {
+		select {
+		case signal := <-signals:
+			close(stopping)
+			if a.shutdownMode != ShutdownDrain {
+				cancel()
+			}
+			a.logger.Info("received-signal", lager.Data{"signal": signal.String()})
+		case <-ctx.Done():
+		}
+	}()
+
 	close(ready)
 
 	var hasWork chan struct{}
 	hasWork = a.batch.HasWork
+	var fetchRetry <-chan time.Time
 
 	for {
 		select {
-		case <-hasWork:
-			logger := a.logger.Session("auction")
-
-			logger.Info("fetching-cell-reps")
-			clients, err := a.delegate.FetchCellReps()
-			if err != nil {
-				logger.Error("failed-to-fetch-reps", err)
-				time.Sleep(time.Second)
-				hasWork = make(chan struct{}, 1)
-				hasWork <- struct{}{}
-				break
-			}
-			logger.Info("fetched-cell-reps", lager.Data{"cell-reps-count": len(clients)})
+		case <-stopping:
+			a.shutdown()
+			return nil
+		default:
+		}
 
-			hasWork = a.batch.HasWork
+		select {
+		case <-hasWork:
+		case <-fetchRetry:
+		case <-stopping:
+			a.shutdown()
+			return nil
+		}
 
-			logger.Info("fetching-zone-state")
-			fetchStatesStartTime := time.Now()
-			zones := FetchStateAndBuildZones(logger, a.workPool, clients, a.metricEmitter)
-			fetchStateDuration := time.Since(fetchStatesStartTime)
-			err = a.metricEmitter.FetchStatesCompleted(fetchStateDuration)
-			if err != nil {
-				logger.Error("failed-sending-fetch-states-completed-metric", err)
-			}
+		auctionCtx, cancelAuction := a.auctionContext(ctx)
+		var err error
+		hasWork, err = a.auction(auctionCtx)
+		cancelAuction()
 
-			cellCount := 0
-			for zone, cells := range zones {
-				logger.Info("zone-state", lager.Data{"zone": zone, "cell-count": len(cells)})
-				cellCount += len(cells)
-			}
-			logger.Info("fetched-zone-state", lager.Data{
-				"cell-state-count":    cellCount,
-				"num-failed-requests": len(clients) - cellCount,
-				"duration":            fetchStateDuration.String(),
+		fetchRetry = nil
+		if err != nil {
+			backoff := a.fetchCellRepsRetryBackoff()
+			a.logger.Info("backing-off-fetching-cell-reps", lager.Data{
+				"consecutive-failures": a.fetchCellRepsFailures,
+				"backoff":              backoff.String(),
 			})
+			fetchRetry = a.clock.NewTimer(backoff).C()
+		}
+	}
+}
 
-			logger.Info("fetching-auctions")
-			lrpAuctions, taskAuctions := a.batch.DedupeAndDrain()
-			logger.Info("fetched-auctions", lager.Data{
-				"lrp-start-auctions": len(lrpAuctions),
-				"task-auctions":      len(taskAuctions),
-			})
-			if len(lrpAuctions) == 0 && len(taskAuctions) == 0 {
-				logger.Info("nothing-to-auction")
-				break
-			}
+// fetchCellRepsRetryBackoff returns how long to wait before fetching the cell
+// reps again. Up to half of the backoff is taken off at random so that
+// auctioneers do not hammer a recovering cell registry in lockstep.
+func (a *auctionRunner) fetchCellRepsRetryBackoff() time.Duration {
+	backoff := fetchCellRepsBackoff.backoff(a.fetchCellRepsFailures)
+	return backoff - time.Duration(a.fetchCellRepsRNG.Int63n(int64(backoff/2)+1))
+}
 
-			logger.Info("scheduling")
-			auctionRequest := auctiontypes.AuctionRequest{
-				LRPs:  lrpAuctions,
-				Tasks: taskAuctions,
-			}
+// auction runs a single auction and returns the channel that signals the next
+// one. It returns a nil channel and an error when the cell reps could not be
+// fetched, so that work queued in the meantime waits for the backoff instead
+// of fetching them again straight away.
+func (a *auctionRunner) auction(ctx context.Context) (chan struct{}, error) {
+	logger := a.logger.Session("auction")
 
-			scheduler := NewScheduler(a.workPool, zones, a.clock, logger, a.startingContainerWeight, a.startingContainerCountMaximum)
-			auctionResults := scheduler.Schedule(auctionRequest)
-			logger.Info("scheduled", lager.Data{
-				"successful-lrp-start-auctions": len(auctionResults.SuccessfulLRPs),
-				"successful-task-auctions":      len(auctionResults.SuccessfulTasks),
-				"failed-lrp-start-auctions":     len(auctionResults.FailedLRPs),
-				"failed-task-auctions":          len(auctionResults.FailedTasks),
-			})
+	logger.Info("fetching-cell-reps")
+	clients, err := a.delegate.FetchCellReps()
+	if err != nil {
+		logger.Error("failed-to-fetch-reps", err)
+		a.fetchCellRepsFailures++
+		a.metricEmitter.FetchCellRepsFailures(a.fetchCellRepsFailures)
+		return nil, err
+	}
+	if a.fetchCellRepsFailures > 0 {
+		a.fetchCellRepsFailures = 0
+		a.metricEmitter.FetchCellRepsFailures(0)
+	}
+	logger.Info("fetched-cell-reps", lager.Data{"cell-reps-count": len(clients)})
 
-			a.metricEmitter.AuctionCompleted(auctionResults)
-			a.delegate.AuctionCompleted(auctionResults)
-		case <-signals:
-			return nil
-		}
+	logger.Info("fetching-zone-state")
+	fetchStatesStartTime := time.Now()
+	zones := FetchStateAndBuildZonesContext(ctx, logger, a.workPool, clients, a.metricEmitter)
+	if a.topology != nil {
+		ResolveTopology(zones, a.topology)
+	}
+	fetchStateDuration := time.Since(fetchStatesStartTime)
+	err = a.metricEmitter.FetchStatesCompleted(fetchStateDuration)
+	if err != nil {
+		logger.Error("failed-sending-fetch-states-completed-metric", err)
 	}
+
+	cellCount := 0
+	for zone, cells := range zones {
+		logger.Info("zone-state", lager.Data{"zone": zone, "cell-count": len(cells)})
+		cellCount += len(cells)
+	}
+	logger.Info("fetched-zone-state", lager.Data{
+		"cell-state-count":    cellCount,
+		"num-failed-requests": len(clients) - cellCount,
+		"duration":            fetchStateDuration.String(),
+	})
+
+	logger.Info("fetching-auctions")
+	a.watchCancellations()
+	lrpAuctions, taskAuctions := a.batch.DedupeAndDrain()
+	a.metricEmitter.QueueDepth(a.batch.Len())
+	logger.Info("fetched-auctions", lager.Data{
+		"lrp-start-auctions": len(lrpAuctions),
+		"task-auctions":      len(taskAuctions),
+	})
+	if len(lrpAuctions) == 0 && len(taskAuctions) == 0 {
+		logger.Info("nothing-to-auction")
+		a.takeCancellations()
+		return a.batch.HasWork, nil
+	}
+
+	logger.Info("scheduling")
+	auctionRequest := auctiontypes.AuctionRequest{
+		LRPs:  lrpAuctions,
+		Tasks: taskAuctions,
+	}
+
+	scheduler := NewScheduler(a.workPool, zones, a.clock, logger, a.startingContainerWeight, a.startingContainerCountMaximum, a.schedulerOptions...)
+	auctionResults := scheduler.ScheduleContext(ctx, auctionRequest)
+	// cancellations that arrive once the retries are registered withdraw them
+	// from the retrier instead
+	a.retrier.lock.Lock()
+	cancelledLRPs, cancelledTasks := a.takeCancellations()
+	withdrawCancelled(&auctionResults, cancelledLRPs, cancelledTasks)
+	if a.retryPolicy != nil {
+		a.retryFailed(logger, &auctionResults)
+	}
+	a.retrier.lock.Unlock()
+	logger.Info("scheduled", lager.Data{
+		"successful-lrp-start-auctions": len(auctionResults.SuccessfulLRPs),
+		"successful-task-auctions":      len(auctionResults.SuccessfulTasks),
+		"failed-lrp-start-auctions":     len(auctionResults.FailedLRPs),
+		"failed-task-auctions":          len(auctionResults.FailedTasks),
+		"cancelled-lrp-start-auctions":  len(auctionResults.CancelledLRPs),
+		"cancelled-task-auctions":       len(auctionResults.CancelledTasks),
+	})
+
+	a.metricEmitter.AuctionCompleted(auctionResults)
+	a.delegate.AuctionCompleted(auctionResults)
+	a.batch.Completed(completedAuctions(auctionResults))
+	return a.batch.HasWork, nil
 }
 
-func (a *auctionRunner) ScheduleLRPsForAuctions(lrpStarts []auctioneer.LRPStartRequest) {
-	a.batch.AddLRPStarts(lrpStarts)
+func (a *auctionRunner) ScheduleLRPsForAuctions(lrpStarts []auctioneer.LRPStartRequest) error {
+	err := a.batch.AddLRPStarts(lrpStarts)
+	a.metricEmitter.QueueDepth(a.batch.Len())
+	return err
 }
 
-func (a *auctionRunner) ScheduleTasksForAuctions(tasks []auctioneer.TaskStartRequest) {
-	a.batch.AddTasks(tasks)
+func (a *auctionRunner) ScheduleTasksForAuctions(tasks []auctioneer.TaskStartRequest) error {
+	err := a.batch.AddTasks(tasks)
+	a.metricEmitter.QueueDepth(a.batch.Len())
+	return err
+}
+
+func (a *auctionRunner) ScheduleTaskGroupForAuction(group string, tasks []auctioneer.TaskStartRequest) error {
+	err := a.batch.AddTaskGroup(group, tasks)
+	a.metricEmitter.QueueDepth(a.batch.Len())
+	return err
+}
+
+// completedAuctions returns the auctions with a final result. Auctions waiting
+// to be retried are not completed yet.
+func completedAuctions(results auctiontypes.AuctionResults) ([]auctiontypes.LRPAuction, []auctiontypes.TaskAuction) {
+	lrpAuctions := make([]auctiontypes.LRPAuction, 0, len(results.SuccessfulLRPs)+len(results.FailedLRPs)+len(results.CancelledLRPs))
+	lrpAuctions = append(lrpAuctions, results.SuccessfulLRPs...)
+	lrpAuctions = append(lrpAuctions, results.FailedLRPs...)
+	lrpAuctions = append(lrpAuctions, results.CancelledLRPs...)
+
+	taskAuctions := make([]auctiontypes.TaskAuction, 0, len(results.SuccessfulTasks)+len(results.FailedTasks)+len(results.CancelledTasks))
+	taskAuctions = append(taskAuctions, results.SuccessfulTasks...)
+	taskAuctions = append(taskAuctions, results.FailedTasks...)
+	taskAuctions = append(taskAuctions, results.CancelledTasks...)
+	return lrpAuctions, taskAuctions
 }
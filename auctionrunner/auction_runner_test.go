@@ -0,0 +1,461 @@
+package auctionrunner_test
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/workpool"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/auction/auctiontypes/fakes"
+	"code.cloudfoundry.org/auctioneer"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/rep/repfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+type runnerDelegate struct {
+	lock      sync.Mutex
+	client    rep.Client
+	completed []auctiontypes.AuctionResults
+}
+
+func (d *runnerDelegate) FetchCellReps() (map[string]rep.Client, error) {
+	return map[string]rep.Client{"A-cell": d.client}, nil
+}
+
+func (d *runnerDelegate) AuctionCompleted(results auctiontypes.AuctionResults) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.completed = append(d.completed, results)
+}
+
+func (d *runnerDelegate) Completed() []auctiontypes.AuctionResults {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]auctiontypes.AuctionResults{}, d.completed...)
+}
+
+type handBackDelegate struct {
+	*runnerDelegate
+
+	handedBackLRPs  []auctiontypes.LRPAuction
+	handedBackTasks []auctiontypes.TaskAuction
+}
+
+func (d *handBackDelegate) AuctionsHandedBack(lrpAuctions []auctiontypes.LRPAuction, taskAuctions []auctiontypes.TaskAuction) {
+	d.handedBackLRPs = lrpAuctions
+	d.handedBackTasks = taskAuctions
+}
+
+// failingFetchDelegate fails to fetch the cell reps the given number of times
+// before fetching them from runnerDelegate.
+type failingFetchDelegate struct {
+	*runnerDelegate
+
+	failures int
+	fetches  int
+}
+
+func (d *failingFetchDelegate) FetchCellReps() (map[string]rep.Client, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.fetches++
+	if d.fetches <= d.failures {
+		return nil, errors.New("cell registry unavailable")
+	}
+	return map[string]rep.Client{"A-cell": d.client}, nil
+}
+
+func (d *failingFetchDelegate) Fetches() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.fetches
+}
+
+// blockFirstPerform holds the first commit to the client until release is
+// closed.
+func blockFirstPerform(client *repfakes.FakeSimClient, release chan struct{}) func(lager.Logger, rep.Work) (rep.Work, error) {
+	return func(lager.Logger, rep.Work) (rep.Work, error) {
+		if client.PerformCallCount() == 1 {
+			<-release
+		}
+		return rep.Work{}, nil
+	}
+}
+
+func auctionedLRPs(results []auctiontypes.AuctionResults) []string {
+	guids := []string{}
+	for _, result := range results {
+		for _, lrpAuction := range append(result.SuccessfulLRPs, result.FailedLRPs...) {
+			guids = append(guids, lrpAuction.ProcessGuid)
+		}
+	}
+	return guids
+}
+
+var _ = Describe("Shutting down", func() {
+	var (
+		client      *repfakes.FakeSimClient
+		release     chan struct{}
+		delegate    *runnerDelegate
+		handBack    *handBackDelegate
+		runDelegate auctiontypes.AuctionRunnerDelegate
+		workPool    *workpool.WorkPool
+		logger      *lagertest.TestLogger
+		mode        auctionrunner.ShutdownMode
+		signals     chan os.Signal
+		runErr      chan error
+		lrpStart    auctioneer.LRPStartRequest
+		queuedStart auctioneer.LRPStartRequest
+		queuedTask  auctioneer.TaskStartRequest
+	)
+
+	BeforeEach(func() {
+		var err error
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		logger = lagertest.NewTestLogger("shutdown")
+		release = make(chan struct{})
+		client = &repfakes.FakeSimClient{}
+		client.StateReturns(BuildCellState("A-cell", "A-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0), nil)
+		client.PerformStub = blockFirstPerform(client, release)
+		delegate = &runnerDelegate{client: client}
+		handBack = &handBackDelegate{runnerDelegate: delegate}
+		runDelegate = handBack
+		signals = make(chan os.Signal)
+		runErr = make(chan error, 1)
+
+		resource := rep.NewResource(10, 10, 10)
+		placement := rep.NewPlacementConstraint(linuxRootFSURL, []string{}, []string{})
+		lrpStart = auctioneer.NewLRPStartRequest("pg-1", "domain", []int{0}, resource, placement)
+		queuedStart = auctioneer.NewLRPStartRequest("pg-2", "domain", []int{0}, resource, placement)
+		queuedTask = auctioneer.NewTaskStartRequest(*BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}))
+	})
+
+	JustBeforeEach(func() {
+		runner := auctionrunner.New(
+			logger,
+			runDelegate,
+			&fakes.FakeAuctionMetricEmitterDelegate{},
+			fakeclock.NewFakeClock(time.Now()),
+			workPool,
+			0.0,
+			0,
+			auctionrunner.WithShutdownMode(mode),
+		)
+		runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{lrpStart})
+
+		go func() {
+			runErr <- runner.Run(signals, make(chan struct{}))
+		}()
+
+		Eventually(client.PerformCallCount).Should(Equal(1))
+		runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{queuedStart})
+		runner.ScheduleTasksForAuctions([]auctioneer.TaskStartRequest{queuedTask})
+
+		signals <- os.Interrupt
+		Eventually(logger).Should(gbytes.Say("received-signal"))
+		close(release)
+
+		Eventually(runErr).Should(Receive(BeNil()))
+	})
+
+	AfterEach(func() {
+		workPool.Stop()
+	})
+
+	Context("when discarding queued auctions", func() {
+		BeforeEach(func() {
+			mode = auctionrunner.ShutdownDiscard
+		})
+
+		It("does not auction or hand back the queued auctions", func() {
+			Expect(auctionedLRPs(delegate.Completed())).To(ConsistOf("pg-1"))
+			Expect(handBack.handedBackLRPs).To(BeEmpty())
+			Expect(handBack.handedBackTasks).To(BeEmpty())
+			Expect(logger).To(gbytes.Say("discarding-queued-auctions"))
+		})
+	})
+
+	Context("when draining queued auctions", func() {
+		BeforeEach(func() {
+			mode = auctionrunner.ShutdownDrain
+		})
+
+		It("lets the auction in progress finish", func() {
+			completed := delegate.Completed()
+			Expect(completed).To(HaveLen(2))
+			Expect(completed[0].SuccessfulLRPs).To(HaveLen(1))
+			Expect(completed[0].SuccessfulLRPs[0].ProcessGuid).To(Equal("pg-1"))
+		})
+
+		It("runs one final auction for the queued auctions", func() {
+			Expect(auctionedLRPs(delegate.Completed())).To(ConsistOf("pg-1", "pg-2"))
+			Expect(handBack.handedBackLRPs).To(BeEmpty())
+		})
+	})
+
+	Context("when handing back queued auctions", func() {
+		BeforeEach(func() {
+			mode = auctionrunner.ShutdownHandBack
+		})
+
+		It("hands the queued auctions back to the delegate", func() {
+			Expect(auctionedLRPs(delegate.Completed())).To(ConsistOf("pg-1"))
+
+			Expect(handBack.handedBackLRPs).To(HaveLen(1))
+			Expect(handBack.handedBackLRPs[0].ProcessGuid).To(Equal("pg-2"))
+			Expect(handBack.handedBackTasks).To(HaveLen(1))
+			Expect(handBack.handedBackTasks[0].TaskGuid).To(Equal("tg-1"))
+		})
+
+		Context("when the delegate cannot take auctions back", func() {
+			BeforeEach(func() {
+				runDelegate = delegate
+			})
+
+			It("discards the queued auctions", func() {
+				Expect(auctionedLRPs(delegate.Completed())).To(ConsistOf("pg-1"))
+				Expect(logger).To(gbytes.Say("discarding-queued-auctions"))
+			})
+		})
+	})
+})
+
+var _ = Describe("Scheduling auctions", func() {
+	var (
+		metricEmitter *fakes.FakeAuctionMetricEmitterDelegate
+		runner        auctiontypes.AuctionRunner
+		workPool      *workpool.WorkPool
+		lrpStart      auctioneer.LRPStartRequest
+	)
+
+	BeforeEach(func() {
+		var err error
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		metricEmitter = &fakes.FakeAuctionMetricEmitterDelegate{}
+		runner = auctionrunner.New(
+			lagertest.NewTestLogger("scheduling"),
+			&runnerDelegate{},
+			metricEmitter,
+			fakeclock.NewFakeClock(time.Now()),
+			workPool,
+			0.0,
+			0,
+			auctionrunner.WithBatchOptions(auctionrunner.WithCapacity(2)),
+		)
+
+		lrpStart = BuildLRPStartRequest("pg-1", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{})
+	})
+
+	AfterEach(func() {
+		workPool.Stop()
+	})
+
+	It("emits the queue depth", func() {
+		Expect(runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{lrpStart})).To(Succeed())
+		Expect(runner.ScheduleTasksForAuctions([]auctioneer.TaskStartRequest{
+			BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10),
+		})).To(Succeed())
+
+		Expect(metricEmitter.QueueDepthCallCount()).To(Equal(2))
+		Expect(metricEmitter.QueueDepthArgsForCall(0)).To(Equal(1))
+		Expect(metricEmitter.QueueDepthArgsForCall(1)).To(Equal(2))
+	})
+
+	It("rejects auctions when the batch is full", func() {
+		Expect(runner.ScheduleTaskGroupForAuction("gang", []auctioneer.TaskStartRequest{
+			BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10),
+			BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+		})).To(Succeed())
+
+		err := runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{lrpStart})
+		Expect(err).To(BeAssignableToTypeOf(auctiontypes.BatchFullError{}))
+		Expect(metricEmitter.QueueDepthArgsForCall(1)).To(Equal(2))
+	})
+})
+
+var _ = Describe("Fetching cell reps", func() {
+	var (
+		clock         *fakeclock.FakeClock
+		metricEmitter *fakes.FakeAuctionMetricEmitterDelegate
+		delegate      *failingFetchDelegate
+		workPool      *workpool.WorkPool
+		runner        auctiontypes.AuctionRunner
+		signals       chan os.Signal
+		runErr        chan error
+	)
+
+	BeforeEach(func() {
+		var err error
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		clock = fakeclock.NewFakeClock(time.Now())
+		metricEmitter = &fakes.FakeAuctionMetricEmitterDelegate{}
+		client := &repfakes.FakeSimClient{}
+		client.StateReturns(BuildCellState("A-cell", "A-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0), nil)
+		delegate = &failingFetchDelegate{runnerDelegate: &runnerDelegate{client: client}, failures: 2}
+		signals = make(chan os.Signal)
+		runErr = make(chan error, 1)
+
+		runner = auctionrunner.New(
+			lagertest.NewTestLogger("fetching"),
+			delegate,
+			metricEmitter,
+			clock,
+			workPool,
+			0.0,
+			0,
+			auctionrunner.WithFetchCellRepsJitter(rand.New(rand.NewSource(1))),
+		)
+		Expect(runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+			auctioneer.NewLRPStartRequest("pg-1", "domain", []int{0}, rep.NewResource(10, 10, 10), rep.NewPlacementConstraint(linuxRootFSURL, []string{}, []string{})),
+		})).To(Succeed())
+
+		go func() {
+			runErr <- runner.Run(signals, make(chan struct{}))
+		}()
+		Eventually(delegate.Fetches).Should(Equal(1))
+	})
+
+	AfterEach(func() {
+		workPool.Stop()
+	})
+
+	Context("when fetching the cell reps fails", func() {
+		AfterEach(func() {
+			signals <- os.Interrupt
+			Eventually(runErr).Should(Receive(BeNil()))
+		})
+
+		It("fetches them again after a growing backoff on the clock", func() {
+			Consistently(delegate.Fetches).Should(Equal(1))
+
+			clock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(delegate.Fetches).Should(Equal(2))
+
+			clock.WaitForWatcherAndIncrement(time.Second - time.Nanosecond)
+			Consistently(delegate.Fetches).Should(Equal(2))
+
+			clock.Increment(time.Second + time.Nanosecond)
+			Eventually(delegate.Fetches).Should(Equal(3))
+			Eventually(delegate.Completed).Should(HaveLen(1))
+		})
+
+		It("queues work during the backoff without fetching them again", func() {
+			Expect(runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				auctioneer.NewLRPStartRequest("pg-2", "domain", []int{0}, rep.NewResource(10, 10, 10), rep.NewPlacementConstraint(linuxRootFSURL, []string{}, []string{})),
+			})).To(Succeed())
+			Consistently(delegate.Fetches).Should(Equal(1))
+
+			clock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(delegate.Fetches).Should(Equal(2))
+
+			Expect(runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				auctioneer.NewLRPStartRequest("pg-3", "domain", []int{0}, rep.NewResource(10, 10, 10), rep.NewPlacementConstraint(linuxRootFSURL, []string{}, []string{})),
+			})).To(Succeed())
+			Consistently(delegate.Fetches).Should(Equal(2))
+
+			clock.WaitForWatcherAndIncrement(2 * time.Second)
+			Eventually(delegate.Completed).Should(HaveLen(1))
+			Expect(auctionedLRPs(delegate.Completed())).To(ConsistOf("pg-1", "pg-2", "pg-3"))
+		})
+
+		It("emits the number of consecutive failures", func() {
+			clock.WaitForWatcherAndIncrement(time.Second)
+			Eventually(delegate.Fetches).Should(Equal(2))
+			clock.WaitForWatcherAndIncrement(2 * time.Second)
+			Eventually(delegate.Completed).Should(HaveLen(1))
+
+			Expect(metricEmitter.FetchCellRepsFailuresCallCount()).To(Equal(3))
+			Expect(metricEmitter.FetchCellRepsFailuresArgsForCall(0)).To(Equal(1))
+			Expect(metricEmitter.FetchCellRepsFailuresArgsForCall(1)).To(Equal(2))
+			Expect(metricEmitter.FetchCellRepsFailuresArgsForCall(2)).To(Equal(0))
+		})
+	})
+
+	It("stops while backing off", func() {
+		signals <- os.Interrupt
+		Eventually(runErr).Should(Receive(BeNil()))
+		Expect(delegate.Fetches()).To(Equal(1))
+	})
+})
+
+var _ = Describe("Timing out auctions", func() {
+	var (
+		clock    *fakeclock.FakeClock
+		client   *repfakes.FakeSimClient
+		release  chan struct{}
+		delegate *runnerDelegate
+		workPool *workpool.WorkPool
+		signals  chan os.Signal
+		runErr   chan error
+	)
+
+	BeforeEach(func() {
+		var err error
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		clock = fakeclock.NewFakeClock(time.Now())
+		release = make(chan struct{})
+		client = &repfakes.FakeSimClient{}
+		release := release
+		client.StateStub = func(lager.Logger) (rep.CellState, error) {
+			<-release
+			return BuildCellState("A-cell", "A-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0), nil
+		}
+		delegate = &runnerDelegate{client: client}
+		signals = make(chan os.Signal)
+		runErr = make(chan error, 1)
+
+		runner := auctionrunner.New(
+			lagertest.NewTestLogger("timeout"),
+			delegate,
+			&fakes.FakeAuctionMetricEmitterDelegate{},
+			clock,
+			workPool,
+			0.0,
+			0,
+			auctionrunner.WithAuctionTimeout(10*time.Second),
+		)
+		Expect(runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+			auctioneer.NewLRPStartRequest("pg-1", "domain", []int{0}, rep.NewResource(10, 10, 10), rep.NewPlacementConstraint(linuxRootFSURL, []string{}, []string{})),
+		})).To(Succeed())
+
+		go func() {
+			runErr <- runner.Run(signals, make(chan struct{}))
+		}()
+		Eventually(client.StateCallCount).Should(Equal(1))
+	})
+
+	AfterEach(func() {
+		close(release)
+		signals <- os.Interrupt
+		Eventually(runErr).Should(Receive(BeNil()))
+		workPool.Stop()
+	})
+
+	It("drops slow cells once the timeout has passed on the clock", func() {
+		Consistently(delegate.Completed).Should(BeEmpty())
+
+		clock.WaitForWatcherAndIncrement(10 * time.Second)
+		Eventually(delegate.Completed).Should(HaveLen(1))
+		Expect(delegate.Completed()[0].FailedLRPs).To(HaveLen(1))
+	})
+})
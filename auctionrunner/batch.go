@@ -2,6 +2,7 @@ package auctionrunner
 
 import (
 	"sync"
+	"time"
 
 	"code.cloudfoundry.org/auction/auctiontypes"
 	"code.cloudfoundry.org/auctioneer"
@@ -17,18 +18,100 @@ type Batch struct {
 	lock         *sync.Mutex
 	HasWork      chan struct{}
 	clock        clock.Clock
+	prioritizer  auctiontypes.Prioritizer
+	capper       auctiontypes.InstanceCapper
+	affinity     auctiontypes.AffinityAssigner
+	spread       auctiontypes.SpreadAssigner
+	window       time.Duration // 0 signals work as soon as it arrives
+	maxWait      time.Duration // 0 lets the window extend without limit
+	maxSize      int           // 0 drains every queued auction
+	capacity     int           // 0 queues without limit
+	journal      auctiontypes.BatchJournal
+	dedupe       DedupePolicy
+	waiting      bool
+	windowStart  time.Time
+	lastAdded    time.Time
 }
 
-func NewBatch(clock clock.Clock) *Batch {
-	return &Batch{
+type BatchOption func(*Batch)
+
+// WithPrioritizer sets the priority of every auction added to the batch.
+// Without a prioritizer all auctions have the default priority.
+func WithPrioritizer(prioritizer auctiontypes.Prioritizer) BatchOption {
+	return func(b *Batch) {
+		b.prioritizer = prioritizer
+	}
+}
+
+// WithInstanceCapper sets the instance caps of every LRP auction added to the
+// batch.
+func WithInstanceCapper(capper auctiontypes.InstanceCapper) BatchOption {
+	return func(b *Batch) {
+		b.capper = capper
+	}
+}
+
+// WithAffinityAssigner sets the affinity rules of every LRP auction added to
+// the batch.
+func WithAffinityAssigner(assigner auctiontypes.AffinityAssigner) BatchOption {
+	return func(b *Batch) {
+		b.affinity = assigner
+	}
+}
+
+// WithSpreadAssigner sets the spread constraint of every LRP auction added to
+// the batch.
+func WithSpreadAssigner(assigner auctiontypes.SpreadAssigner) BatchOption {
+	return func(b *Batch) {
+		b.spread = assigner
+	}
+}
+
+// A DedupePolicy decides which of several queued auctions for the same LRP
+// instance or task is auctioned.
+type DedupePolicy int
+
+const (
+	// DedupeKeepFirst auctions the first request and drops the later ones.
+	DedupeKeepFirst DedupePolicy = iota
+	// DedupeKeepLatest auctions the latest request, so that resubmitted
+	// requests replace stale ones, but keeps the time the first was queued.
+	DedupeKeepLatest
+)
+
+// WithDedupePolicy sets how the batch dedupes auctions when it is drained.
+// Without a policy the first request is kept.
+func WithDedupePolicy(policy DedupePolicy) BatchOption {
+	return func(b *Batch) {
+		b.dedupe = policy
+	}
+}
+
+// WithCapacity limits the number of auctions queued in the batch. Adding more
+// than fit fails with an auctiontypes.BatchFullError.
+func WithCapacity(capacity int) BatchOption {
+	return func(b *Batch) {
+		b.capacity = capacity
+	}
+}
+
+func NewBatch(clock clock.Clock, options ...BatchOption) *Batch {
+	b := &Batch{
 		lrpAuctions: []auctiontypes.LRPAuction{},
 		lock:        &sync.Mutex{},
 		clock:       clock,
 		HasWork:     make(chan struct{}, 1),
 	}
+	for _, option := range options {
+		option(b)
+	}
+	if b.journal != nil {
+		b.replay()
+	}
+	return b
 }
 
-func (b *Batch) AddLRPStarts(starts []auctioneer.LRPStartRequest) {
+func (b *Batch) AddLRPStarts(starts []auctioneer.LRPStartRequest) error {
 	auctions := make([]auctiontypes.LRPAuction, 0, len(starts))
 	now := b.clock.Now()
 	for i := range starts {
@@ -36,67 +119,192 @@ func (b *Batch) AddLRPStarts(starts []auctioneer.LRPStartRequest) {
 		for _, index := range start.Indices {
 			lrpKey := models.NewActualLRPKey(start.ProcessGuid, int32(index), start.Domain)
 			auction := auctiontypes.NewLRPAuction(rep.NewLRP("", lrpKey, start.Resource, start.PlacementConstraint), now)
+			if b.prioritizer != nil {
+				auction.Priority = b.prioritizer.LRPPriority(&auction.LRP)
+			}
+			if b.capper != nil {
+				auction.Caps = b.capper.InstanceCaps(&auction.LRP)
+			}
+			if b.affinity != nil {
+				auction.Affinity = b.affinity.AffinityRules(&auction.LRP)
+			}
+			if b.spread != nil {
+				auction.Spread = b.spread.SpreadConstraint(&auction.LRP)
+			}
 			auctions = append(auctions, auction)
 		}
 	}
 
 	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.makeRoom(len(auctions)); err != nil {
+		return err
+	}
+	if b.journal != nil {
+		if err := b.journal.Added(auctions, nil); err != nil {
+			return err
+		}
+	}
 	b.lrpAuctions = append(b.lrpAuctions, auctions...)
 	b.claimToHaveWork()
-	b.lock.Unlock()
+	return nil
+}
+
+func (b *Batch) AddTasks(tasks []auctioneer.TaskStartRequest) error {
+	return b.addTaskAuctions(b.taskAuctionsFor(tasks))
+}
+
+// AddTaskGroup adds tasks that must be placed all together or not at all. Every
+// task in the group gets the highest priority of its members, so that the
+// group is auctioned as a whole.
+func (b *Batch) AddTaskGroup(group string, tasks []auctioneer.TaskStartRequest) error {
+	auctions := b.taskAuctionsFor(tasks)
+
+	var priority auctiontypes.Priority
+	for i := range auctions {
+		if i == 0 || auctions[i].Priority > priority {
+			priority = auctions[i].Priority
+		}
+	}
+	for i := range auctions {
+		auctions[i].Group = group
+		auctions[i].Priority = priority
+	}
+
+	return b.addTaskAuctions(auctions)
 }
 
-func (b *Batch) AddTasks(tasks []auctioneer.TaskStartRequest) {
+func (b *Batch) taskAuctionsFor(tasks []auctioneer.TaskStartRequest) []auctiontypes.TaskAuction {
 	auctions := make([]auctiontypes.TaskAuction, 0, len(tasks))
 	now := b.clock.Now()
 	for i := range tasks {
-		auctions = append(auctions, auctiontypes.NewTaskAuction(tasks[i].Task, now))
+		auction := auctiontypes.NewTaskAuction(tasks[i].Task, now)
+		if b.prioritizer != nil {
+			auction.Priority = b.prioritizer.TaskPriority(&auction.Task)
+		}
+		auctions = append(auctions, auction)
 	}
+	return auctions
+}
 
+func (b *Batch) addTaskAuctions(auctions []auctiontypes.TaskAuction) error {
 	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.makeRoom(len(auctions)); err != nil {
+		return err
+	}
+	if b.journal != nil {
+		if err := b.journal.Added(nil, auctions); err != nil {
+			return err
+		}
+	}
 	b.taskAuctions = append(b.taskAuctions, auctions...)
 	b.claimToHaveWork()
-	b.lock.Unlock()
+	return nil
+}
+
+// Len returns the number of auctions queued in the batch.
+func (b *Batch) Len() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.lrpAuctions) + len(b.taskAuctions)
+}
+
+func (b *Batch) makeRoom(requested int) error {
+	queued := len(b.lrpAuctions) + len(b.taskAuctions)
+	if b.capacity > 0 && queued+requested > b.capacity {
+		return auctiontypes.BatchFullError{Capacity: b.capacity, Queued: queued, Requested: requested}
+	}
+	return nil
 }
 
 func (b *Batch) DedupeAndDrain() ([]auctiontypes.LRPAuction, []auctiontypes.TaskAuction) {
 	b.lock.Lock()
+	defer b.lock.Unlock()
 	lrpAuctions := b.lrpAuctions
 	taskAuctions := b.taskAuctions
 	b.lrpAuctions = []auctiontypes.LRPAuction{}
 	b.taskAuctions = []auctiontypes.TaskAuction{}
+	b.windowStart = time.Time{}
 	select {
 	case <-b.HasWork:
 	default:
 	}
-	b.lock.Unlock()
+
+	// duplicates are merged into the auction they are deduped against
+	mergedLRPAuctions := []auctiontypes.LRPAuction{}
+	mergedTaskAuctions := []auctiontypes.TaskAuction{}
 
 	dedupedLRPAuctions := []auctiontypes.LRPAuction{}
-	presentLRPAuctions := map[string]bool{}
+	presentLRPAuctions := map[string]int{}
 	for _, startAuction := range lrpAuctions {
 		id := startAuction.Identifier()
-		if presentLRPAuctions[id] {
+		if i, ok := presentLRPAuctions[id]; ok {
+			if b.dedupe == DedupeKeepLatest {
+				mergedLRPAuctions = append(mergedLRPAuctions, dedupedLRPAuctions[i])
+				startAuction.QueueTime = earliest(dedupedLRPAuctions[i].QueueTime, startAuction.QueueTime)
+				dedupedLRPAuctions[i] = startAuction
+			} else {
+				mergedLRPAuctions = append(mergedLRPAuctions, startAuction)
+			}
 			continue
 		}
-		presentLRPAuctions[id] = true
+		presentLRPAuctions[id] = len(dedupedLRPAuctions)
 		dedupedLRPAuctions = append(dedupedLRPAuctions, startAuction)
 	}
 
 	dedupedTaskAuctions := []auctiontypes.TaskAuction{}
-	presentTaskAuctions := map[string]bool{}
+	presentTaskAuctions := map[string]int{}
 	for _, taskAuction := range taskAuctions {
 		id := taskAuction.Identifier()
-		if presentTaskAuctions[id] {
+		if i, ok := presentTaskAuctions[id]; ok {
+			if b.dedupe == DedupeKeepLatest {
+				mergedTaskAuctions = append(mergedTaskAuctions, dedupedTaskAuctions[i])
+				taskAuction.QueueTime = earliest(dedupedTaskAuctions[i].QueueTime, taskAuction.QueueTime)
+				dedupedTaskAuctions[i] = taskAuction
+			} else {
+				mergedTaskAuctions = append(mergedTaskAuctions, taskAuction)
+			}
 			continue
 		}
-		presentTaskAuctions[id] = true
+		presentTaskAuctions[id] = len(dedupedTaskAuctions)
 		dedupedTaskAuctions = append(dedupedTaskAuctions, taskAuction)
 	}
+	b.Completed(mergedLRPAuctions, mergedTaskAuctions)
+
+	if b.maxSize > 0 && len(dedupedLRPAuctions)+len(dedupedTaskAuctions) > b.maxSize {
+		dedupedLRPAuctions, dedupedTaskAuctions, b.lrpAuctions, b.taskAuctions = takeInArrivalOrder(b.maxSize, dedupedLRPAuctions, dedupedTaskAuctions)
+		b.signalWork()
+	}
 
 	return dedupedLRPAuctions, dedupedTaskAuctions
 }
 
+func earliest(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
 func (b *Batch) claimToHaveWork() {
+	if b.window == 0 || b.full() {
+		b.signalWork()
+		return
+	}
+
+	now := b.clock.Now()
+	b.lastAdded = now
+	if b.windowStart.IsZero() {
+		b.windowStart = now
+	}
+	if !b.waiting {
+		b.waiting = true
+		go b.waitForWindow()
+	}
+}
+
+func (b *Batch) signalWork() {
 	select {
 	case b.HasWork <- struct{}{}:
 	default:
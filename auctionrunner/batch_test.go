@@ -7,6 +7,7 @@ import (
 	"code.cloudfoundry.org/auction/auctiontypes"
 	"code.cloudfoundry.org/auctioneer"
 	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/rep"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -64,6 +65,152 @@ var _ = Describe("Batch", func() {
 		})
 	})
 
+	Context("with a prioritizer", func() {
+		BeforeEach(func() {
+			batch = auctionrunner.NewBatch(clock, auctionrunner.WithPrioritizer(testPrioritizer{"pg-1": 5, "tg-1": 7}))
+		})
+
+		It("sets the priority of the auctions it creates", func() {
+			batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0, 1}, "linux", 10, 10, 10, []string{}, []string{}),
+				BuildLRPStartRequest("pg-2", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+			})
+			batch.AddTasks([]auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10),
+				BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+			})
+
+			lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(HaveLen(3))
+			Expect(lrpAuctions[0].Priority).To(Equal(auctiontypes.Priority(5)))
+			Expect(lrpAuctions[1].Priority).To(Equal(auctiontypes.Priority(5)))
+			Expect(lrpAuctions[2].Priority).To(BeZero())
+
+			Expect(taskAuctions).To(HaveLen(2))
+			Expect(taskAuctions[0].Priority).To(Equal(auctiontypes.Priority(7)))
+			Expect(taskAuctions[1].Priority).To(BeZero())
+		})
+	})
+
+	Context("with an instance capper", func() {
+		BeforeEach(func() {
+			batch = auctionrunner.NewBatch(clock, auctionrunner.WithInstanceCapper(testCapper{"pg-1": {PerCell: 1, PerZone: 2}}))
+		})
+
+		It("sets the instance caps of the LRP auctions it creates", func() {
+			batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+				BuildLRPStartRequest("pg-2", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+			})
+
+			lrpAuctions, _ := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(HaveLen(2))
+			Expect(lrpAuctions[0].Caps).To(Equal(auctiontypes.InstanceCaps{PerCell: 1, PerZone: 2}))
+			Expect(lrpAuctions[1].Caps).To(BeZero())
+		})
+	})
+
+	Context("with an affinity assigner", func() {
+		var rules auctiontypes.AffinityRules
+
+		BeforeEach(func() {
+			rules = auctiontypes.AffinityRules{Required: []string{"pg-2"}, PreferredAnti: []string{"pg-3"}}
+			batch = auctionrunner.NewBatch(clock, auctionrunner.WithAffinityAssigner(testAffinityAssigner{"pg-1": rules}))
+		})
+
+		It("sets the affinity rules of the LRP auctions it creates", func() {
+			batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+			})
+
+			lrpAuctions, _ := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(HaveLen(1))
+			Expect(lrpAuctions[0].Affinity).To(Equal(rules))
+		})
+	})
+
+	Context("with a spread assigner", func() {
+		var spread auctiontypes.SpreadConstraint
+
+		BeforeEach(func() {
+			spread = auctiontypes.SpreadConstraint{MaxSkew: 1, WhenUnsatisfiable: auctiontypes.SpreadFail}
+			batch = auctionrunner.NewBatch(clock, auctionrunner.WithSpreadAssigner(testSpreadAssigner{"pg-1": spread}))
+		})
+
+		It("sets the spread constraint of the LRP auctions it creates", func() {
+			batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+			})
+
+			lrpAuctions, _ := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(HaveLen(1))
+			Expect(lrpAuctions[0].Spread).To(Equal(spread))
+		})
+	})
+
+	Context("with a capacity", func() {
+		BeforeEach(func() {
+			batch = auctionrunner.NewBatch(clock, auctionrunner.WithCapacity(3))
+			Expect(batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0, 1}, "linux", 10, 10, 10, []string{}, []string{}),
+			})).To(Succeed())
+		})
+
+		It("queues auctions that fit", func() {
+			Expect(batch.AddTasks([]auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10),
+			})).To(Succeed())
+			Expect(batch.Len()).To(Equal(3))
+		})
+
+		It("rejects auctions that do not fit", func() {
+			err := batch.AddTasks([]auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10),
+				BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+			})
+			Expect(err).To(Equal(auctiontypes.BatchFullError{Capacity: 3, Queued: 2, Requested: 2}))
+
+			err = batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-2", "domain", []int{0, 1}, "linux", 10, 10, 10, []string{}, []string{}),
+			})
+			Expect(err).To(BeAssignableToTypeOf(auctiontypes.BatchFullError{}))
+
+			Expect(batch.Len()).To(Equal(2))
+		})
+
+		It("makes room again once drained", func() {
+			batch.DedupeAndDrain()
+			Expect(batch.AddTaskGroup("gang", []auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10),
+				BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+				BuildTaskStartRequest("tg-3", "domain", "linux", 10, 10, 10),
+			})).To(Succeed())
+		})
+	})
+
+	Context("when adding a task group", func() {
+		BeforeEach(func() {
+			batch = auctionrunner.NewBatch(clock, auctionrunner.WithPrioritizer(testPrioritizer{"tg-2": 3}))
+			batch.AddTaskGroup("gang", []auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10),
+				BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+			})
+		})
+
+		It("groups the task auctions at the highest priority of the group", func() {
+			_, taskAuctions := batch.DedupeAndDrain()
+			Expect(taskAuctions).To(HaveLen(2))
+			for _, taskAuction := range taskAuctions {
+				Expect(taskAuction.Group).To(Equal("gang"))
+				Expect(taskAuction.Priority).To(Equal(auctiontypes.Priority(3)))
+			}
+		})
+
+		It("should have work", func() {
+			Expect(batch.HasWork).To(Receive())
+		})
+	})
+
 	Describe("DedupeAndDrain", func() {
 		BeforeEach(func() {
 			batch.AddLRPStarts([]auctioneer.LRPStartRequest{
@@ -115,4 +262,55 @@ var _ = Describe("Batch", func() {
 			Expect(batch.HasWork).NotTo(Receive())
 		})
 	})
+
+	Describe("deduping resubmitted requests", func() {
+		var firstQueued time.Time
+
+		resubmit := func() {
+			batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{1}, "linux", 10, 10, 10, []string{}, []string{}),
+				BuildLRPStartRequest("pg-2", "domain", []int{2}, "linux", 10, 10, 10, []string{}, []string{}),
+			})
+			batch.AddTasks([]auctioneer.TaskStartRequest{BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10)})
+			firstQueued = clock.Now()
+			clock.Increment(time.Minute)
+
+			batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{1}, "linux", 20, 30, 40, []string{}, []string{}),
+			})
+			batch.AddTasks([]auctioneer.TaskStartRequest{BuildTaskStartRequest("tg-1", "domain", "linux", 20, 30, 40)})
+		}
+
+		It("keeps the first request by default", func() {
+			resubmit()
+
+			lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+			Expect(lrpAuctions[0].Resource).To(Equal(rep.NewResource(10, 10, 10)))
+			Expect(taskAuctions[0].Resource).To(Equal(rep.NewResource(10, 10, 10)))
+		})
+
+		Context("with the latest-wins policy", func() {
+			BeforeEach(func() {
+				batch = auctionrunner.NewBatch(clock, auctionrunner.WithDedupePolicy(auctionrunner.DedupeKeepLatest))
+				resubmit()
+			})
+
+			It("auctions the latest request in place of the first", func() {
+				lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+				Expect(lrpAuctions).To(HaveLen(2))
+				Expect(lrpAuctions[0].ProcessGuid).To(Equal("pg-1"))
+				Expect(lrpAuctions[0].Resource).To(Equal(rep.NewResource(20, 30, 40)))
+				Expect(lrpAuctions[1].ProcessGuid).To(Equal("pg-2"))
+
+				Expect(taskAuctions).To(HaveLen(1))
+				Expect(taskAuctions[0].Resource).To(Equal(rep.NewResource(20, 30, 40)))
+			})
+
+			It("keeps the time the first request was queued", func() {
+				lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+				Expect(lrpAuctions[0].QueueTime).To(Equal(firstQueued))
+				Expect(taskAuctions[0].QueueTime).To(Equal(firstQueued))
+			})
+		})
+	})
 })
@@ -0,0 +1,106 @@
+package auctionrunner
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
+)
+
+// WithBatchWindow holds back new work until no more has arrived for window,
+// so that bursts are auctioned together. Work is never held back for longer
+// than maxWait after the first auction of the round was queued; a maxWait of
+// 0 lets the window extend for as long as work keeps arriving.
+func WithBatchWindow(window, maxWait time.Duration) BatchOption {
+	return func(b *Batch) {
+		b.window = window
+		b.maxWait = maxWait
+	}
+}
+
+// WithMaxBatchSize limits the auctions drained per round to size, taken in the
+// order they arrived. The rest stay queued for the next round. Task groups are
+// never split, so a round may exceed size by the rest of a group.
+func WithMaxBatchSize(size int) BatchOption {
+	return func(b *Batch) {
+		b.maxSize = size
+	}
+}
+
+// waitForWindow signals work once the window has passed since the last
+// auction arrived, or maxWait has passed since the round started.
+func (b *Batch) waitForWindow() {
+	wait := b.window
+	for {
+		<-b.clock.After(wait)
+
+		b.lock.Lock()
+		if len(b.lrpAuctions) == 0 && len(b.taskAuctions) == 0 {
+			b.waiting = false
+			b.lock.Unlock()
+			return
+		}
+
+		wait = b.windowRemaining(b.clock.Now())
+		if wait <= 0 {
+			b.waiting = false
+			b.signalWork()
+			b.lock.Unlock()
+			return
+		}
+		b.lock.Unlock()
+	}
+}
+
+func (b *Batch) windowRemaining(now time.Time) time.Duration {
+	deadline := b.lastAdded.Add(b.window)
+	if b.maxWait > 0 && b.windowStart.Add(b.maxWait).Before(deadline) {
+		deadline = b.windowStart.Add(b.maxWait)
+	}
+	return deadline.Sub(now)
+}
+
+func (b *Batch) full() bool {
+	return b.maxSize > 0 && len(b.lrpAuctions)+len(b.taskAuctions) >= b.maxSize
+}
+
+// takeInArrivalOrder takes up to max auctions, oldest first, and returns the
+// rest separately. A task group is taken with every queued member, wherever
+// they are in the queue, or not at all, so it may take the batch over max.
+func takeInArrivalOrder(max int, lrpAuctions []auctiontypes.LRPAuction, taskAuctions []auctiontypes.TaskAuction) ([]auctiontypes.LRPAuction, []auctiontypes.TaskAuction, []auctiontypes.LRPAuction, []auctiontypes.TaskAuction) {
+	taken := make([]bool, len(taskAuctions))
+	l, t, count := 0, 0, 0
+	for count < max && (l < len(lrpAuctions) || t < len(taskAuctions)) {
+		if t < len(taskAuctions) && taken[t] {
+			t++
+			continue
+		}
+		if t == len(taskAuctions) || (l < len(lrpAuctions) && !taskAuctions[t].QueueTime.Before(lrpAuctions[l].QueueTime)) {
+			l++
+			count++
+			continue
+		}
+
+		taken[t] = true
+		count++
+		if group := taskAuctions[t].Group; group != "" {
+			for i := t + 1; i < len(taskAuctions); i++ {
+				if !taken[i] && taskAuctions[i].Group == group {
+					taken[i] = true
+					count++
+				}
+			}
+		}
+		t++
+	}
+
+	takenTasks := make([]auctiontypes.TaskAuction, 0, count-l)
+	restTasks := make([]auctiontypes.TaskAuction, 0, len(taskAuctions)-(count-l))
+	for i := range taskAuctions {
+		if taken[i] {
+			takenTasks = append(takenTasks, taskAuctions[i])
+		} else {
+			restTasks = append(restTasks, taskAuctions[i])
+		}
+	}
+	return lrpAuctions[:l:l], takenTasks, lrpAuctions[l:], restTasks
+}
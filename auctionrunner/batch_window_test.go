@@ -0,0 +1,165 @@
+package auctionrunner_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auctioneer"
+	"code.cloudfoundry.org/clock/fakeclock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batching", func() {
+	var batch *auctionrunner.Batch
+	var clock *fakeclock.FakeClock
+
+	addLRP := func(processGuid string) {
+		batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+			BuildLRPStartRequest(processGuid, "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+		})
+	}
+
+	addTask := func(taskGuid string) {
+		batch.AddTasks([]auctioneer.TaskStartRequest{BuildTaskStartRequest(taskGuid, "domain", "linux", 10, 10, 10)})
+	}
+
+	BeforeEach(func() {
+		clock = fakeclock.NewFakeClock(time.Now())
+	})
+
+	Context("with a batching window", func() {
+		BeforeEach(func() {
+			batch = auctionrunner.NewBatch(clock, auctionrunner.WithBatchWindow(10*time.Second, 15*time.Second))
+			addLRP("pg-1")
+		})
+
+		It("does not have work until the window has passed", func() {
+			Consistently(batch.HasWork).ShouldNot(Receive())
+
+			clock.WaitForWatcherAndIncrement(10 * time.Second)
+			Eventually(batch.HasWork).Should(Receive())
+		})
+
+		It("extends the window when more work arrives", func() {
+			clock.Increment(5 * time.Second)
+			addLRP("pg-2")
+
+			clock.WaitForWatcherAndIncrement(5 * time.Second)
+			Consistently(batch.HasWork).ShouldNot(Receive())
+
+			clock.WaitForWatcherAndIncrement(5 * time.Second)
+			Eventually(batch.HasWork).Should(Receive())
+
+			lrpAuctions, _ := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(HaveLen(2))
+		})
+
+		It("does not hold work back for longer than the maximum wait", func() {
+			clock.Increment(5 * time.Second)
+			addLRP("pg-2")
+
+			clock.WaitForWatcherAndIncrement(5 * time.Second)
+			addLRP("pg-3")
+			Consistently(batch.HasWork).ShouldNot(Receive())
+
+			clock.WaitForWatcherAndIncrement(5 * time.Second)
+			Eventually(batch.HasWork).Should(Receive())
+		})
+
+		It("starts a new window once the batch is drained", func() {
+			clock.WaitForWatcherAndIncrement(10 * time.Second)
+			Eventually(batch.HasWork).Should(Receive())
+			batch.DedupeAndDrain()
+
+			addLRP("pg-2")
+			Consistently(batch.HasWork).ShouldNot(Receive())
+
+			clock.WaitForWatcherAndIncrement(10 * time.Second)
+			Eventually(batch.HasWork).Should(Receive())
+		})
+	})
+
+	Context("with a maximum batch size", func() {
+		BeforeEach(func() {
+			batch = auctionrunner.NewBatch(clock, auctionrunner.WithMaxBatchSize(2))
+		})
+
+		It("drains at most that many auctions in the order they arrived", func() {
+			addLRP("pg-1")
+			clock.Increment(time.Second)
+			addTask("tg-1")
+			clock.Increment(time.Second)
+			addLRP("pg-2")
+
+			lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(HaveLen(1))
+			Expect(lrpAuctions[0].ProcessGuid).To(Equal("pg-1"))
+			Expect(taskAuctions).To(HaveLen(1))
+			Expect(taskAuctions[0].TaskGuid).To(Equal("tg-1"))
+		})
+
+		It("leaves the rest queued for the next round", func() {
+			addLRP("pg-1")
+			addLRP("pg-2")
+			addLRP("pg-3")
+			batch.DedupeAndDrain()
+
+			Expect(batch.HasWork).To(Receive())
+			lrpAuctions, _ := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(HaveLen(1))
+			Expect(lrpAuctions[0].ProcessGuid).To(Equal("pg-3"))
+		})
+
+		It("does not split task groups", func() {
+			addTask("tg-1")
+			batch.AddTaskGroup("gang", []auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+				BuildTaskStartRequest("tg-3", "domain", "linux", 10, 10, 10),
+			})
+
+			_, taskAuctions := batch.DedupeAndDrain()
+			Expect(taskAuctions).To(HaveLen(3))
+		})
+
+		It("takes every queued member of a task group together", func() {
+			addTask("tg-1")
+			clock.Increment(time.Second)
+			Expect(batch.AddTaskGroup("gang", []auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+			})).To(Succeed())
+			clock.Increment(time.Second)
+			addTask("tg-3")
+			clock.Increment(time.Second)
+			Expect(batch.AddTaskGroup("gang", []auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-4", "domain", "linux", 10, 10, 10),
+			})).To(Succeed())
+
+			_, taskAuctions := batch.DedupeAndDrain()
+			taskGuids := []string{}
+			for _, taskAuction := range taskAuctions {
+				taskGuids = append(taskGuids, taskAuction.TaskGuid)
+			}
+			Expect(taskGuids).To(Equal([]string{"tg-1", "tg-2", "tg-4"}))
+
+			_, taskAuctions = batch.DedupeAndDrain()
+			Expect(taskAuctions).To(HaveLen(1))
+			Expect(taskAuctions[0].TaskGuid).To(Equal("tg-3"))
+		})
+
+		Context("with a batching window", func() {
+			BeforeEach(func() {
+				batch = auctionrunner.NewBatch(clock, auctionrunner.WithBatchWindow(10*time.Second, 0), auctionrunner.WithMaxBatchSize(2))
+			})
+
+			It("has work as soon as the batch is full", func() {
+				addLRP("pg-1")
+				Consistently(batch.HasWork).ShouldNot(Receive())
+
+				addLRP("pg-2")
+				Expect(batch.HasWork).To(Receive())
+			})
+		})
+	})
+})
@@ -0,0 +1,182 @@
+package auctionrunner
+
+import (
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/rep"
+)
+
+// CancelLRPs removes the queued auctions of the given LRP instances and
+// returns them.
+func (b *Batch) CancelLRPs(keys []models.ActualLRPKey) []auctiontypes.LRPAuction {
+	cancelled := lrpIdentifiers(keys)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	removed := []auctiontypes.LRPAuction{}
+	lrpAuctions := make([]auctiontypes.LRPAuction, 0, len(b.lrpAuctions))
+	for _, lrpAuction := range b.lrpAuctions {
+		if _, ok := cancelled[lrpAuction.Identifier()]; ok {
+			removed = append(removed, lrpAuction)
+			continue
+		}
+		lrpAuctions = append(lrpAuctions, lrpAuction)
+	}
+	b.lrpAuctions = lrpAuctions
+
+	if b.journal != nil && len(removed) > 0 {
+		b.journal.Drained(removed, nil)
+	}
+	return removed
+}
+
+// CancelTasks removes the queued auctions of the given tasks and returns them.
+func (b *Batch) CancelTasks(taskGuids []string) []auctiontypes.TaskAuction {
+	cancelled := taskIdentifiers(taskGuids)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	removed := []auctiontypes.TaskAuction{}
+	taskAuctions := make([]auctiontypes.TaskAuction, 0, len(b.taskAuctions))
+	for _, taskAuction := range b.taskAuctions {
+		if _, ok := cancelled[taskAuction.Identifier()]; ok {
+			removed = append(removed, taskAuction)
+			continue
+		}
+		taskAuctions = append(taskAuctions, taskAuction)
+	}
+	b.taskAuctions = taskAuctions
+
+	if b.journal != nil && len(removed) > 0 {
+		b.journal.Drained(nil, removed)
+	}
+	return removed
+}
+
+// CancelLRPAuctions withdraws the given LRP instances from auction. Queued
+// auctions and auctions waiting to be retried are dropped; auctions already in
+// progress are reported as cancelled when the auction completes.
+func (a *auctionRunner) CancelLRPAuctions(keys []models.ActualLRPKey) {
+	ids := lrpIdentifiers(keys)
+
+	a.retrier.lock.Lock()
+	a.cancelLock.Lock()
+	if a.auctioning {
+		for id := range ids {
+			a.cancelledLRPs[id] = struct{}{}
+		}
+	}
+	a.cancelLock.Unlock()
+
+	removed := a.batch.CancelLRPs(keys)
+	retrying := a.retrier.cancelLRPs(ids)
+	a.retrier.lock.Unlock()
+
+	a.batch.Completed(retrying, nil)
+	a.logger.Info("cancelled-lrp-start-auctions", lager.Data{"requested": len(keys), "queued": len(removed), "retrying": len(retrying)})
+	a.metricEmitter.QueueDepth(a.batch.Len())
+}
+
+// CancelTaskAuctions withdraws the given tasks from auction. Queued auctions
+// and auctions waiting to be retried are dropped; auctions already in progress
+// are reported as cancelled when the auction completes.
+func (a *auctionRunner) CancelTaskAuctions(taskGuids []string) {
+	ids := taskIdentifiers(taskGuids)
+
+	a.retrier.lock.Lock()
+	a.cancelLock.Lock()
+	if a.auctioning {
+		for id := range ids {
+			a.cancelledTasks[id] = struct{}{}
+		}
+	}
+	a.cancelLock.Unlock()
+
+	removed := a.batch.CancelTasks(taskGuids)
+	retrying := a.retrier.cancelTasks(ids)
+	a.retrier.lock.Unlock()
+
+	a.batch.Completed(nil, retrying)
+	a.logger.Info("cancelled-task-auctions", lager.Data{"requested": len(taskGuids), "queued": len(removed), "retrying": len(retrying)})
+	a.metricEmitter.QueueDepth(a.batch.Len())
+}
+
+// watchCancellations starts collecting the cancellations of the auction about
+// to be drained from the batch.
+func (a *auctionRunner) watchCancellations() {
+	a.cancelLock.Lock()
+	a.auctioning = true
+	a.cancelledLRPs = map[string]struct{}{}
+	a.cancelledTasks = map[string]struct{}{}
+	a.cancelLock.Unlock()
+}
+
+// takeCancellations stops collecting cancellations and returns the identifiers
+// of the cancelled LRPs and tasks.
+func (a *auctionRunner) takeCancellations() (map[string]struct{}, map[string]struct{}) {
+	a.cancelLock.Lock()
+	defer a.cancelLock.Unlock()
+
+	cancelledLRPs, cancelledTasks := a.cancelledLRPs, a.cancelledTasks
+	a.auctioning = false
+	a.cancelledLRPs, a.cancelledTasks = nil, nil
+	return cancelledLRPs, cancelledTasks
+}
+
+// withdrawCancelled moves the cancelled auctions in results to the cancelled
+// results.
+func withdrawCancelled(results *auctiontypes.AuctionResults, cancelledLRPs, cancelledTasks map[string]struct{}) {
+	if len(cancelledLRPs) > 0 {
+		results.SuccessfulLRPs = withdrawLRPs(results.SuccessfulLRPs, cancelledLRPs, &results.CancelledLRPs)
+		results.FailedLRPs = withdrawLRPs(results.FailedLRPs, cancelledLRPs, &results.CancelledLRPs)
+	}
+	if len(cancelledTasks) > 0 {
+		results.SuccessfulTasks = withdrawTasks(results.SuccessfulTasks, cancelledTasks, &results.CancelledTasks)
+		results.FailedTasks = withdrawTasks(results.FailedTasks, cancelledTasks, &results.CancelledTasks)
+	}
+}
+
+func withdrawLRPs(lrpAuctions []auctiontypes.LRPAuction, cancelled map[string]struct{}, withdrawn *[]auctiontypes.LRPAuction) []auctiontypes.LRPAuction {
+	kept := lrpAuctions[:0]
+	for _, lrpAuction := range lrpAuctions {
+		if _, ok := cancelled[lrpAuction.Identifier()]; ok {
+			*withdrawn = append(*withdrawn, lrpAuction)
+			continue
+		}
+		kept = append(kept, lrpAuction)
+	}
+	return kept
+}
+
+func withdrawTasks(taskAuctions []auctiontypes.TaskAuction, cancelled map[string]struct{}, withdrawn *[]auctiontypes.TaskAuction) []auctiontypes.TaskAuction {
+	kept := taskAuctions[:0]
+	for _, taskAuction := range taskAuctions {
+		if _, ok := cancelled[taskAuction.Identifier()]; ok {
+			*withdrawn = append(*withdrawn, taskAuction)
+			continue
+		}
+		kept = append(kept, taskAuction)
+	}
+	return kept
+}
+
+func lrpIdentifiers(keys []models.ActualLRPKey) map[string]struct{} {
+	ids := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		lrp := rep.LRP{ActualLRPKey: key}
+		ids[lrp.Identifier()] = struct{}{}
+	}
+	return ids
+}
+
+func taskIdentifiers(taskGuids []string) map[string]struct{} {
+	ids := make(map[string]struct{}, len(taskGuids))
+	for _, taskGuid := range taskGuids {
+		task := rep.Task{TaskGuid: taskGuid}
+		ids[task.Identifier()] = struct{}{}
+	}
+	return ids
+}
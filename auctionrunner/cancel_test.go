@@ -0,0 +1,170 @@
+package auctionrunner_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/workpool"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes/fakes"
+	"code.cloudfoundry.org/auctioneer"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/rep/repfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cancelling auctions", func() {
+	var clock *fakeclock.FakeClock
+
+	BeforeEach(func() {
+		clock = fakeclock.NewFakeClock(time.Now())
+	})
+
+	Describe("Batch", func() {
+		var batch *auctionrunner.Batch
+
+		BeforeEach(func() {
+			batch = auctionrunner.NewBatch(clock)
+			Expect(batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0, 1}, "linux", 10, 10, 10, []string{}, []string{}),
+			})).To(Succeed())
+			Expect(batch.AddTasks([]auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10),
+				BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+			})).To(Succeed())
+		})
+
+		It("removes the queued auctions of cancelled LRP instances", func() {
+			removed := batch.CancelLRPs([]models.ActualLRPKey{models.NewActualLRPKey("pg-1", 1, "domain")})
+			Expect(removed).To(HaveLen(1))
+			Expect(removed[0].Index).To(BeEquivalentTo(1))
+
+			lrpAuctions, _ := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(HaveLen(1))
+			Expect(lrpAuctions[0].Index).To(BeEquivalentTo(0))
+		})
+
+		It("removes the queued auctions of cancelled tasks", func() {
+			removed := batch.CancelTasks([]string{"tg-2", "tg-unknown"})
+			Expect(removed).To(HaveLen(1))
+
+			_, taskAuctions := batch.DedupeAndDrain()
+			Expect(taskAuctions).To(HaveLen(1))
+			Expect(taskAuctions[0].TaskGuid).To(Equal("tg-1"))
+		})
+
+		Context("with a journal", func() {
+			var dir string
+
+			BeforeEach(func() {
+				var err error
+				dir, err = ioutil.TempDir("", "cancel")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(dir)
+			})
+
+			It("does not queue cancelled auctions again after a restart", func() {
+				logger := lagertest.NewTestLogger("cancel")
+				path := filepath.Join(dir, "batch.journal")
+
+				journal, err := auctionrunner.NewFileJournal(logger, path, 0)
+				Expect(err).NotTo(HaveOccurred())
+				batch = auctionrunner.NewBatch(clock, auctionrunner.WithJournal(journal))
+				Expect(batch.AddTasks([]auctioneer.TaskStartRequest{
+					BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10),
+				})).To(Succeed())
+				batch.CancelTasks([]string{"tg-1"})
+				Expect(journal.Close()).To(Succeed())
+
+				journal, err = auctionrunner.NewFileJournal(logger, path, 0)
+				Expect(err).NotTo(HaveOccurred())
+				defer journal.Close()
+				_, taskAuctions := auctionrunner.NewBatch(clock, auctionrunner.WithJournal(journal)).DedupeAndDrain()
+				Expect(taskAuctions).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("runner", func() {
+		var (
+			client   *repfakes.FakeSimClient
+			release  chan struct{}
+			delegate *runnerDelegate
+			workPool *workpool.WorkPool
+			signals  chan os.Signal
+			runErr   chan error
+		)
+
+		BeforeEach(func() {
+			var err error
+			workPool, err = workpool.NewWorkPool(5)
+			Expect(err).NotTo(HaveOccurred())
+
+			release = make(chan struct{})
+			client = &repfakes.FakeSimClient{}
+			client.StateReturns(BuildCellState("A-cell", "A-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0), nil)
+			client.PerformStub = blockFirstPerform(client, release)
+			delegate = &runnerDelegate{client: client}
+			signals = make(chan os.Signal)
+			runErr = make(chan error, 1)
+		})
+
+		AfterEach(func() {
+			signals <- os.Interrupt
+			Eventually(runErr).Should(Receive(BeNil()))
+			workPool.Stop()
+		})
+
+		It("reports auctions cancelled while they were in progress as cancelled", func() {
+			runner := auctionrunner.New(
+				lagertest.NewTestLogger("cancel"),
+				delegate,
+				&fakes.FakeAuctionMetricEmitterDelegate{},
+				clock,
+				workPool,
+				0.0,
+				0,
+			)
+
+			Expect(runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+				auctioneer.NewLRPStartRequest("pg-1", "domain", []int{0, 1}, rep.NewResource(10, 10, 10), rep.NewPlacementConstraint(linuxRootFSURL, []string{}, []string{})),
+			})).To(Succeed())
+			Expect(runner.ScheduleTasksForAuctions([]auctioneer.TaskStartRequest{
+				auctioneer.NewTaskStartRequest(*BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{})),
+			})).To(Succeed())
+
+			go func() {
+				runErr <- runner.Run(signals, make(chan struct{}))
+			}()
+			Eventually(client.PerformCallCount).Should(Equal(1))
+
+			runner.CancelLRPAuctions([]models.ActualLRPKey{models.NewActualLRPKey("pg-1", 1, "domain")})
+			runner.CancelTaskAuctions([]string{"tg-1"})
+			close(release)
+
+			Eventually(delegate.Completed).Should(HaveLen(1))
+			results := delegate.Completed()[0]
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Index).To(BeEquivalentTo(0))
+			Expect(results.SuccessfulTasks).To(BeEmpty())
+
+			Expect(results.CancelledLRPs).To(HaveLen(1))
+			Expect(results.CancelledLRPs[0].Index).To(BeEquivalentTo(1))
+			Expect(results.CancelledLRPs[0].Winner).To(Equal("A-cell"))
+			Expect(results.CancelledTasks).To(HaveLen(1))
+			Expect(results.CancelledTasks[0].TaskGuid).To(Equal("tg-1"))
+		})
+	})
+})
@@ -1,31 +1,62 @@
 package auctionrunner
 
 import (
+	"time"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/rep"
 )
 
 const LocalityOffset = 1000
 
+// AffinityOffset is added to the score of a cell for every preferred affinity
+// rule it breaks.
+const AffinityOffset = 100
+
 type Cell struct {
-	logger lager.Logger
-	Guid   string
-	client rep.Client
-	state  rep.CellState
+	logger   lager.Logger
+	Guid     string
+	client   rep.Client
+	state    rep.CellState
+	topology auctiontypes.Topology
+
+	// instances counts the LRPs of every process guid in state, including
+	// those reserved during the current auction.
+	instances map[string]int
 
 	workToCommit rep.Work
+	// unconfirmedWork was sent in commits that failed with an error, so the
+	// cell may or may not have started it.
+	unconfirmedWork rep.Work
+
+	reserving     bool // the work is committed in two phases
+	reservationID string
+	heldWork      rep.Work
 }
 
 func NewCell(logger lager.Logger, guid string, client rep.Client, state rep.CellState) *Cell {
+	instances := make(map[string]int)
+	for i := range state.LRPs {
+		instances[state.LRPs[i].ProcessGuid]++
+	}
+
 	return &Cell{
 		logger:       logger,
 		Guid:         guid,
 		client:       client,
 		state:        state,
+		instances:    instances,
 		workToCommit: rep.Work{CellID: guid},
 	}
 }
 
+// State returns the cell state, including any work reserved on the cell
+// during the current auction. Callers must not modify it.
+func (c *Cell) State() rep.CellState {
+	return c.state
+}
+
 func (c *Cell) StartingContainerCount() int {
 	return c.state.StartingContainerCount
 }
@@ -44,25 +75,14 @@ func (c *Cell) MatchPlacementTags(placementTags []string) bool {
 }
 
 func (c *Cell) ScoreForLRP(lrp *rep.LRP, startingContainerWeight float64) (float64, error) {
-	proxiedLRP := rep.Resource{
-		MemoryMB: lrp.Resource.MemoryMB + int32(c.state.ProxyMemoryAllocationMB),
-		DiskMB:   lrp.Resource.DiskMB,
-		MaxPids:  lrp.Resource.MaxPids,
-	}
+	proxiedLRP := c.proxiedResource(lrp)
 
 	err := c.state.ResourceMatch(&proxiedLRP)
 	if err != nil {
 		return 0, err
 	}
 
-	numberOfInstancesWithMatchingProcessGuid := 0
-	for i := range c.state.LRPs {
-		if c.state.LRPs[i].ProcessGuid == lrp.ProcessGuid {
-			numberOfInstancesWithMatchingProcessGuid++
-		}
-	}
-
-	localityScore := LocalityOffset * numberOfInstancesWithMatchingProcessGuid
+	localityScore := LocalityOffset * c.instancesOf(lrp.ProcessGuid)
 
 	resourceScore := c.state.ComputeScore(&proxiedLRP, startingContainerWeight)
 	return resourceScore + float64(localityScore), nil
@@ -86,6 +106,7 @@ func (c *Cell) ReserveLRP(lrp *rep.LRP) error {
 	}
 
 	c.state.AddLRP(lrp)
+	c.instances[lrp.ProcessGuid]++
 	c.workToCommit.LRPs = append(c.workToCommit.LRPs, *lrp)
 	return nil
 }
@@ -101,6 +122,126 @@ func (c *Cell) ReserveTask(task *rep.Task) error {
 	return nil
 }
 
+// fitsWithout reports whether the resource would fit on the cell if the given
+// tasks were removed from it.
+func (c *Cell) fitsWithout(resource *rep.Resource, tasks []rep.Task) bool {
+	state := c.state
+	for i := range tasks {
+		state.AvailableResources.MemoryMB += tasks[i].MemoryMB
+		state.AvailableResources.DiskMB += tasks[i].DiskMB
+		state.AvailableResources.Containers++
+	}
+	return state.ResourceMatch(resource) == nil
+}
+
+// holdForPreemption removes the preempted tasks from the cell and holds their
+// room for the lrp, without committing the lrp to the cell.
+func (c *Cell) holdForPreemption(lrp *rep.LRP, preempted []rep.Task) {
+	for i := range preempted {
+		c.releaseTask(&preempted[i])
+	}
+
+	resource := c.proxiedResource(lrp)
+	c.state.AvailableResources.Subtract(&resource)
+	c.state.LRPs = append(c.state.LRPs, *lrp)
+	c.instances[lrp.ProcessGuid]++
+}
+
+// unreserveLRP undoes ReserveLRP.
+func (c *Cell) unreserveLRP(lrp *rep.LRP) {
+	identifier := lrp.Identifier()
+	for i := len(c.workToCommit.LRPs) - 1; i >= 0; i-- {
+		if c.workToCommit.LRPs[i].Identifier() == identifier {
+			c.workToCommit.LRPs = append(c.workToCommit.LRPs[:i], c.workToCommit.LRPs[i+1:]...)
+			break
+		}
+	}
+	for i := len(c.state.LRPs) - 1; i >= 0; i-- {
+		if c.state.LRPs[i].Identifier() == identifier {
+			c.state.LRPs = append(c.state.LRPs[:i], c.state.LRPs[i+1:]...)
+			c.instances[lrp.ProcessGuid]--
+			break
+		}
+	}
+
+	c.state.AvailableResources.MemoryMB += lrp.MemoryMB
+	c.state.AvailableResources.DiskMB += lrp.DiskMB
+	c.state.AvailableResources.Containers++
+	c.state.StartingContainerCount--
+}
+
+// unreserveTask undoes ReserveTask.
+func (c *Cell) unreserveTask(task *rep.Task) {
+	for i := range c.workToCommit.Tasks {
+		if c.workToCommit.Tasks[i].TaskGuid == task.TaskGuid {
+			c.workToCommit.Tasks = append(c.workToCommit.Tasks[:i], c.workToCommit.Tasks[i+1:]...)
+			break
+		}
+	}
+
+	c.releaseTask(task)
+	c.state.StartingContainerCount--
+}
+
+// releaseTask removes the task from the cell state and gives its resources back.
+func (c *Cell) releaseTask(task *rep.Task) {
+	for i := range c.state.Tasks {
+		if c.state.Tasks[i].TaskGuid == task.TaskGuid {
+			c.state.Tasks = append(c.state.Tasks[:i], c.state.Tasks[i+1:]...)
+			break
+		}
+	}
+
+	c.state.AvailableResources.MemoryMB += task.MemoryMB
+	c.state.AvailableResources.DiskMB += task.DiskMB
+	c.state.AvailableResources.Containers++
+}
+
+func (c *Cell) proxiedResource(lrp *rep.LRP) rep.Resource {
+	return rep.Resource{
+		MemoryMB: lrp.Resource.MemoryMB + int32(c.state.ProxyMemoryAllocationMB),
+		DiskMB:   lrp.Resource.DiskMB,
+		MaxPids:  lrp.Resource.MaxPids,
+	}
+}
+
+// satisfiesAffinity reports whether the cell satisfies the required affinity
+// rules.
+func (c *Cell) satisfiesAffinity(rules auctiontypes.AffinityRules) bool {
+	for _, processGuid := range rules.Required {
+		if c.instancesOf(processGuid) == 0 {
+			return false
+		}
+	}
+	for _, processGuid := range rules.RequiredAnti {
+		if c.instancesOf(processGuid) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// affinityScore penalizes the cell for every preferred affinity rule it
+// breaks.
+func (c *Cell) affinityScore(rules auctiontypes.AffinityRules) float64 {
+	broken := 0
+	for _, processGuid := range rules.Preferred {
+		if c.instancesOf(processGuid) == 0 {
+			broken++
+		}
+	}
+	for _, processGuid := range rules.PreferredAnti {
+		if c.instancesOf(processGuid) > 0 {
+			broken++
+		}
+	}
+	return float64(AffinityOffset * broken)
+}
+
+func (c *Cell) instancesOf(processGuid string) int {
+	return c.instances[processGuid]
+}
+
 func (c *Cell) Commit() rep.Work {
 	if len(c.workToCommit.LRPs) == 0 && len(c.workToCommit.Tasks) == 0 {
 		return rep.Work{}
@@ -109,6 +250,8 @@ func (c *Cell) Commit() rep.Work {
 	failedWork, err := c.client.Perform(c.logger, c.workToCommit)
 	if err != nil {
 		c.logger.Error("failed-to-commit", err, lager.Data{"cell-guid": c.Guid})
+		c.unconfirmedWork.LRPs = append(c.unconfirmedWork.LRPs, c.workToCommit.LRPs...)
+		c.unconfirmedWork.Tasks = append(c.unconfirmedWork.Tasks, c.workToCommit.Tasks...)
 		//an error may indicate partial failure
 		//in this case we don't reschedule work in order to make sure we don't
 		//create duplicates of things -- we'll let the converger figure things out for us later
@@ -116,3 +259,125 @@ func (c *Cell) Commit() rep.Work {
 	}
 	return failedWork
 }
+
+// Reserve asks the cell to hold the work to commit for ttl, and returns the
+// work it rejected. Cells whose client cannot reserve work hold nothing and are
+// committed by Confirm instead.
+func (c *Cell) Reserve(ttl time.Duration) rep.Work {
+	c.reserving, c.reservationID, c.heldWork = false, "", rep.Work{}
+
+	client, ok := c.client.(auctiontypes.ReservingClient)
+	if !ok || (len(c.workToCommit.LRPs) == 0 && len(c.workToCommit.Tasks) == 0) {
+		return rep.Work{}
+	}
+	c.reserving = true
+
+	reservationID, rejectedWork, err := client.Reserve(c.logger, c.workToCommit, ttl)
+	if err != nil {
+		c.logger.Error("failed-to-reserve", err, lager.Data{"cell-guid": c.Guid})
+		// nothing was started, and whatever the cell holds lapses with the ttl
+		return c.workToCommit
+	}
+
+	rejectedLRPs := map[string]struct{}{}
+	for i := range rejectedWork.LRPs {
+		rejectedLRPs[rejectedWork.LRPs[i].Identifier()] = struct{}{}
+	}
+	rejectedTasks := map[string]struct{}{}
+	for i := range rejectedWork.Tasks {
+		rejectedTasks[rejectedWork.Tasks[i].TaskGuid] = struct{}{}
+	}
+
+	c.reservationID = reservationID
+	c.heldWork = rep.Work{CellID: c.Guid}
+	for _, lrp := range c.workToCommit.LRPs {
+		if _, ok := rejectedLRPs[lrp.Identifier()]; !ok {
+			c.heldWork.LRPs = append(c.heldWork.LRPs, lrp)
+		}
+	}
+	for _, task := range c.workToCommit.Tasks {
+		if _, ok := rejectedTasks[task.TaskGuid]; !ok {
+			c.heldWork.Tasks = append(c.heldWork.Tasks, task)
+		}
+	}
+	return rejectedWork
+}
+
+// Confirm starts the work the cell holds and returns the work that failed. A
+// cell that did not reserve its work is committed instead.
+func (c *Cell) Confirm() rep.Work {
+	if !c.reserving {
+		return c.Commit()
+	}
+	if c.reservationID == "" {
+		return rep.Work{}
+	}
+
+	client := c.client.(auctiontypes.ReservingClient)
+	failedWork, err := client.Confirm(c.logger, c.reservationID)
+	c.reservationID = ""
+	if err == auctiontypes.ErrorReservationExpired {
+		c.logger.Error("failed-to-confirm", err, lager.Data{"cell-guid": c.Guid})
+		return c.heldWork
+	}
+	if err != nil {
+		c.logger.Error("failed-to-confirm", err, lager.Data{"cell-guid": c.Guid})
+		c.unconfirmedWork.LRPs = append(c.unconfirmedWork.LRPs, c.heldWork.LRPs...)
+		c.unconfirmedWork.Tasks = append(c.unconfirmedWork.Tasks, c.heldWork.Tasks...)
+		return rep.Work{}
+	}
+	return failedWork
+}
+
+// Release drops the work the cell holds and returns it as failed.
+func (c *Cell) Release() rep.Work {
+	if c.reservationID == "" {
+		return rep.Work{}
+	}
+
+	client := c.client.(auctiontypes.ReservingClient)
+	err := client.Release(c.logger, c.reservationID)
+	if err != nil {
+		// the hold lapses with the ttl
+		c.logger.Error("failed-to-release", err, lager.Data{"cell-guid": c.Guid})
+	}
+	c.reservationID = ""
+	return c.heldWork
+}
+
+// Reconcile fetches the cell's state to find the work of failed commits that
+// the cell did not start. It returns false when the state cannot be fetched,
+// leaving it unknown whether the work was started.
+func (c *Cell) Reconcile() (rep.Work, bool) {
+	missingWork := rep.Work{CellID: c.Guid}
+	if len(c.unconfirmedWork.LRPs) == 0 && len(c.unconfirmedWork.Tasks) == 0 {
+		return missingWork, true
+	}
+
+	state, err := c.client.State(c.logger)
+	if err != nil {
+		c.logger.Error("failed-to-reconcile", err, lager.Data{"cell-guid": c.Guid})
+		return missingWork, false
+	}
+
+	lrps := map[string]struct{}{}
+	for i := range state.LRPs {
+		lrps[state.LRPs[i].Identifier()] = struct{}{}
+	}
+	tasks := map[string]struct{}{}
+	for i := range state.Tasks {
+		tasks[state.Tasks[i].TaskGuid] = struct{}{}
+	}
+
+	for _, lrp := range c.unconfirmedWork.LRPs {
+		if _, ok := lrps[lrp.Identifier()]; !ok {
+			missingWork.LRPs = append(missingWork.LRPs, lrp)
+		}
+	}
+	for _, task := range c.unconfirmedWork.Tasks {
+		if _, ok := tasks[task.TaskGuid]; !ok {
+			missingWork.Tasks = append(missingWork.Tasks, task)
+		}
+	}
+	return missingWork, true
+}
@@ -571,4 +571,49 @@ var _ = Describe("Cell", func() {
 			})
 		})
 	})
+
+	Describe("Reconcile", func() {
+		var lrp rep.LRP
+		var task rep.Task
+
+		BeforeEach(func() {
+			lrp = *BuildLRP("pg-new", "domain", 0, linuxRootFSURL, 20, 10, 10, []string{})
+			task = *BuildTask("tg-new", "domain", linuxRootFSURL, 20, 10, 10, []string{}, []string{})
+			Expect(cell.ReserveLRP(&lrp)).To(Succeed())
+			Expect(cell.ReserveTask(&task)).To(Succeed())
+		})
+
+		Context("when the commit succeeded", func() {
+			It("does not fetch the state", func() {
+				cell.Commit()
+				missingWork, ok := cell.Reconcile()
+				Expect(ok).To(BeTrue())
+				Expect(missingWork.LRPs).To(BeEmpty())
+				Expect(missingWork.Tasks).To(BeEmpty())
+				Expect(client.StateCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when the commit failed with an error", func() {
+			BeforeEach(func() {
+				client.PerformReturns(rep.Work{}, errors.New("boom"))
+				cell.Commit()
+			})
+
+			It("returns the work missing from the cell state", func() {
+				client.StateReturns(rep.CellState{LRPs: []rep.LRP{lrp}}, nil)
+				missingWork, ok := cell.Reconcile()
+				Expect(ok).To(BeTrue())
+				Expect(missingWork).To(Equal(rep.Work{Tasks: []rep.Task{task}, CellID: cell.Guid}))
+			})
+
+			Context("when the state cannot be fetched", func() {
+				It("reports the work as unknown", func() {
+					client.StateReturns(rep.CellState{}, errors.New("boom"))
+					_, ok := cell.Reconcile()
+					Expect(ok).To(BeFalse())
+				})
+			})
+		})
+	})
 })
@@ -0,0 +1,183 @@
+package auctionrunner
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/rep"
+)
+
+// WithCommitRetries places work that cells reject on commit again, on the
+// cells that did not reject any work, and commits it in up to retries more
+// rounds before the auction completes.
+func WithCommitRetries(retries int) SchedulerOption {
+	return func(s *Scheduler) {
+		s.commitRetries = retries
+	}
+}
+
+// replaceRejectedWork takes the rejected work back off the cells that rejected
+// it and places it on the remaining cells, merging the outcome into p. It
+// returns the work rejected by the next commit. Task groups are placed again
+// only when every member was rejected; members of groups that cells partly
+// accepted are returned as rejected, to fail the whole group.
+func (s *Scheduler) replaceRejectedWork(ctx context.Context, p *placements, failedWorks []rep.Work) []rep.Work {
+	request := auctiontypes.AuctionRequest{}
+	rejecting := map[*Cell]struct{}{}
+	partial := partlyRejectedGroups(p, failedWorks)
+	kept := []rep.Work{}
+
+	for _, failedWork := range failedWorks {
+		cell := findCell(s.zones, failedWork.CellID)
+		keptWork := rep.Work{CellID: failedWork.CellID}
+
+		for i := range failedWork.LRPs {
+			identifier := failedWork.LRPs[i].Identifier()
+			if cell != nil {
+				cell.unreserveLRP(&failedWork.LRPs[i])
+				rejecting[cell] = struct{}{}
+			}
+			delete(p.successfulLRPs, identifier)
+
+			lrpAuction := *p.lrpStartAuctionLookup[identifier]
+			lrpAuction.Winner = ""
+			request.LRPs = append(request.LRPs, lrpAuction)
+		}
+
+		for i := range failedWork.Tasks {
+			identifier := failedWork.Tasks[i].Identifier()
+			if _, ok := partial[p.taskAuctionLookup[identifier].Group]; ok {
+				keptWork.Tasks = append(keptWork.Tasks, failedWork.Tasks[i])
+				continue
+			}
+			if cell != nil {
+				cell.unreserveTask(&failedWork.Tasks[i])
+				rejecting[cell] = struct{}{}
+			}
+			delete(p.successfulTasks, identifier)
+
+			taskAuction := *p.taskAuctionLookup[identifier]
+			taskAuction.Winner = ""
+			request.Tasks = append(request.Tasks, taskAuction)
+		}
+
+		if len(keptWork.Tasks) > 0 {
+			kept = append(kept, keptWork)
+		}
+	}
+
+	s.logger.Info("replacing-rejected-work", lager.Data{
+		"lrp-start-auctions": len(request.LRPs),
+		"task-auctions":      len(request.Tasks),
+		"rejecting-cells":    len(rejecting),
+		"incomplete-groups":  len(partial),
+	})
+
+	retry := *s
+	retry.preempted = nil
+	retry.zones = map[string]Zone{}
+	for name, zone := range s.zones {
+		cells := Zone{}
+		for _, cell := range zone {
+			cell.workToCommit = rep.Work{CellID: cell.Guid}
+			if _, ok := rejecting[cell]; !ok {
+				cells = append(cells, cell)
+			}
+		}
+		if len(cells) > 0 {
+			retry.zones[name] = cells
+		}
+	}
+	if len(retry.zones) == 0 {
+		return failedWorks
+	}
+
+	retried := retry.place(request)
+	for identifier, lrpAuction := range retried.lrpStartAuctionLookup {
+		p.lrpStartAuctionLookup[identifier] = lrpAuction
+	}
+	for identifier, lrpAuction := range retried.successfulLRPs {
+		p.successfulLRPs[identifier] = lrpAuction
+	}
+	for identifier, taskAuction := range retried.taskAuctionLookup {
+		p.taskAuctionLookup[identifier] = taskAuction
+	}
+	for identifier, taskAuction := range retried.successfulTasks {
+		p.successfulTasks[identifier] = taskAuction
+	}
+	p.results.FailedLRPs = append(p.results.FailedLRPs, retried.results.FailedLRPs...)
+	p.results.FailedTasks = append(p.results.FailedTasks, retried.results.FailedTasks...)
+	p.results.PreemptedTasks = append(p.results.PreemptedTasks, retried.results.PreemptedTasks...)
+
+	return append(kept, retry.commitCells(ctx)...)
+}
+
+// partlyRejectedGroups returns the task groups that cells rejected some, but
+// not all, of the members of.
+func partlyRejectedGroups(p *placements, failedWorks []rep.Work) map[string]struct{} {
+	rejectedGroups := map[string]struct{}{}
+	rejectedTasks := map[string]struct{}{}
+	for _, failedWork := range failedWorks {
+		for i := range failedWork.Tasks {
+			identifier := failedWork.Tasks[i].Identifier()
+			rejectedTasks[identifier] = struct{}{}
+			if group := p.taskAuctionLookup[identifier].Group; group != "" {
+				rejectedGroups[group] = struct{}{}
+			}
+		}
+	}
+
+	partial := map[string]struct{}{}
+	for identifier, taskAuction := range p.successfulTasks {
+		if _, ok := rejectedGroups[taskAuction.Group]; !ok {
+			continue
+		}
+		if _, ok := rejectedTasks[identifier]; !ok {
+			partial[taskAuction.Group] = struct{}{}
+		}
+	}
+	return partial
+}
+
+// failIncompleteGroups fails the members of every task group that was only
+// partly started, so that no group is reported as placed without all of its
+// members. The members that were started keep their Winner, so that they can
+// be cancelled on that cell.
+func (s *Scheduler) failIncompleteGroups(p *placements, results *auctiontypes.AuctionResults) {
+	failed := map[string]struct{}{}
+	for i := range results.FailedTasks {
+		if group := results.FailedTasks[i].Group; group != "" {
+			failed[group] = struct{}{}
+		}
+	}
+
+	incomplete := map[string]struct{}{}
+	for identifier, taskAuction := range p.successfulTasks {
+		if _, ok := failed[taskAuction.Group]; !ok {
+			continue
+		}
+		incomplete[taskAuction.Group] = struct{}{}
+		delete(p.successfulTasks, identifier)
+
+		results.FailedTasks = append(results.FailedTasks, *taskAuction)
+	}
+
+	for i := range results.FailedTasks {
+		if _, ok := incomplete[results.FailedTasks[i].Group]; ok {
+			results.FailedTasks[i].PlacementError = auctiontypes.ErrorTaskGroupIncomplete.Error()
+		}
+	}
+	for group := range incomplete {
+		s.logger.Info("task-group-partly-committed", lager.Data{"task-group": group})
+	}
+}
+
+func hasRejectedWork(failedWorks []rep.Work) bool {
+	for _, failedWork := range failedWorks {
+		if len(failedWork.LRPs) > 0 || len(failedWork.Tasks) > 0 {
+			return true
+		}
+	}
+	return false
+}
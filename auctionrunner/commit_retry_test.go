@@ -0,0 +1,227 @@
+package auctionrunner_test
+
+import (
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/workpool"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/rep/repfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Commit retries", func() {
+	var (
+		clients     map[string]*repfakes.FakeSimClient
+		zones       map[string]auctionrunner.Zone
+		clock       *fakeclock.FakeClock
+		workPool    *workpool.WorkPool
+		logger      *lagertest.TestLogger
+		options     []auctionrunner.SchedulerOption
+		lrpAuction  auctiontypes.LRPAuction
+		taskAuction auctiontypes.TaskAuction
+		groupTasks  []auctiontypes.TaskAuction
+		results     auctiontypes.AuctionResults
+	)
+
+	BeforeEach(func() {
+		clock = fakeclock.NewFakeClock(time.Now())
+
+		var err error
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		logger = lagertest.NewTestLogger("commit-retries")
+		options = []auctionrunner.SchedulerOption{auctionrunner.WithCommitRetries(1)}
+
+		clients = map[string]*repfakes.FakeSimClient{
+			"A-cell": &repfakes.FakeSimClient{},
+			"B-cell": &repfakes.FakeSimClient{},
+		}
+		zones = map[string]auctionrunner.Zone{
+			"A-zone": auctionrunner.Zone{
+				auctionrunner.NewCell(logger, "A-cell", clients["A-cell"], BuildCellState("A-cell", "A-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+			},
+			"B-zone": auctionrunner.Zone{
+				auctionrunner.NewCell(logger, "B-cell", clients["B-cell"], BuildCellState("B-cell", "B-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, []rep.LRP{
+					*BuildLRP("pg-other", "domain", 0, "", 50, 50, 50, []string{}),
+				}, []string{}, []string{}, []string{}, 0)),
+			},
+		}
+
+		lrpAuction = BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{})
+		taskAuction = BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now())
+
+		groupTasks = nil
+
+		clients["A-cell"].PerformReturnsOnCall(0, rep.Work{LRPs: []rep.LRP{lrpAuction.LRP}, Tasks: []rep.Task{taskAuction.Task}}, nil)
+	})
+
+	JustBeforeEach(func() {
+		scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0, options...)
+		results = scheduler.Schedule(auctiontypes.AuctionRequest{
+			LRPs:  []auctiontypes.LRPAuction{lrpAuction},
+			Tasks: append([]auctiontypes.TaskAuction{taskAuction}, groupTasks...),
+		})
+	})
+
+	AfterEach(func() {
+		workPool.Stop()
+	})
+
+	It("places the rejected work on another cell in the same auction", func() {
+		Expect(results.FailedLRPs).To(BeEmpty())
+		Expect(results.FailedTasks).To(BeEmpty())
+
+		Expect(results.SuccessfulLRPs).To(HaveLen(1))
+		Expect(results.SuccessfulLRPs[0].Winner).To(Equal("B-cell"))
+		Expect(results.SuccessfulTasks).To(HaveLen(1))
+		Expect(results.SuccessfulTasks[0].Winner).To(Equal("B-cell"))
+	})
+
+	It("commits only the rejected work in the next round", func() {
+		Expect(clients["A-cell"].PerformCallCount()).To(Equal(1))
+		Expect(clients["B-cell"].PerformCallCount()).To(Equal(1))
+
+		_, work := clients["B-cell"].PerformArgsForCall(0)
+		Expect(work.LRPs).To(ConsistOf(lrpAuction.LRP))
+		Expect(work.Tasks).To(ConsistOf(taskAuction.Task))
+	})
+
+	Context("when every round is rejected", func() {
+		BeforeEach(func() {
+			clients["B-cell"].PerformReturns(rep.Work{LRPs: []rep.LRP{lrpAuction.LRP}, Tasks: []rep.Task{taskAuction.Task}}, nil)
+		})
+
+		It("fails the work after the last round", func() {
+			Expect(results.SuccessfulLRPs).To(BeEmpty())
+			Expect(results.SuccessfulTasks).To(BeEmpty())
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].Attempts).To(Equal(1))
+		})
+	})
+
+	Context("without commit retries", func() {
+		BeforeEach(func() {
+			options = nil
+		})
+
+		It("fails the rejected work", func() {
+			Expect(results.SuccessfulLRPs).To(BeEmpty())
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(clients["B-cell"].PerformCallCount()).To(BeZero())
+		})
+	})
+
+	Context("when cells reject members of a task group", func() {
+		// rejectGroup rejects the given members of the gang in the first round
+		// a cell is committed to, or in every round when always is set.
+		rejectGroup := func(client *repfakes.FakeSimClient, always bool, taskGuids ...string) {
+			client.PerformStub = func(_ lager.Logger, work rep.Work) (rep.Work, error) {
+				failed := rep.Work{}
+				if !always && client.PerformCallCount() > 1 {
+					return failed, nil
+				}
+				for _, task := range work.Tasks {
+					for _, taskGuid := range taskGuids {
+						if task.TaskGuid == taskGuid {
+							failed.Tasks = append(failed.Tasks, task)
+						}
+					}
+				}
+				return failed, nil
+			}
+		}
+
+		groupResults := func() (successful, failed []auctiontypes.TaskAuction) {
+			for _, taskAuction := range results.SuccessfulTasks {
+				if taskAuction.Group == "gang" {
+					successful = append(successful, taskAuction)
+				}
+			}
+			for _, taskAuction := range results.FailedTasks {
+				if taskAuction.Group == "gang" {
+					failed = append(failed, taskAuction)
+				}
+			}
+			return successful, failed
+		}
+
+		BeforeEach(func() {
+			for i := 0; i < 2; i++ {
+				member := BuildTaskAuction(BuildTask(fmt.Sprintf("gang-%d", i), "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now())
+				member.Group = "gang"
+				groupTasks = append(groupTasks, member)
+			}
+		})
+
+		Context("when only some members are rejected", func() {
+			BeforeEach(func() {
+				rejectGroup(clients["A-cell"], true, "gang-0")
+				rejectGroup(clients["B-cell"], true, "gang-0")
+			})
+
+			It("fails the whole group", func() {
+				successful, failed := groupResults()
+				Expect(successful).To(BeEmpty())
+				Expect(failed).To(HaveLen(2))
+				for _, failedTask := range failed {
+					Expect(failedTask.PlacementError).To(Equal(auctiontypes.ErrorTaskGroupIncomplete.Error()))
+				}
+			})
+
+			It("keeps the cell of the member that was started", func() {
+				_, failed := groupResults()
+				winners := map[string]string{}
+				for _, failedTask := range failed {
+					winners[failedTask.TaskGuid] = failedTask.Winner
+				}
+				Expect(winners["gang-0"]).To(BeEmpty())
+				Expect(winners["gang-1"]).NotTo(BeEmpty())
+			})
+
+			It("does not place the rejected member again on its own", func() {
+				commits := 0
+				for _, client := range clients {
+					for i := 0; i < client.PerformCallCount(); i++ {
+						_, work := client.PerformArgsForCall(i)
+						for _, task := range work.Tasks {
+							if task.TaskGuid == "gang-0" {
+								commits++
+							}
+						}
+					}
+				}
+				Expect(commits).To(Equal(1))
+			})
+		})
+
+		Context("when every member is rejected", func() {
+			BeforeEach(func() {
+				clients["C-cell"] = &repfakes.FakeSimClient{}
+				zones["C-zone"] = auctionrunner.Zone{
+					auctionrunner.NewCell(logger, "C-cell", clients["C-cell"], BuildCellState("C-cell", "C-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+				}
+				for _, client := range clients {
+					rejectGroup(client, false, "gang-0", "gang-1")
+				}
+			})
+
+			It("places the whole group again", func() {
+				successful, failed := groupResults()
+				Expect(failed).To(BeEmpty())
+				Expect(successful).To(HaveLen(2))
+			})
+		})
+	})
+})
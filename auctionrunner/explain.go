@@ -0,0 +1,240 @@
+package auctionrunner
+
+import (
+	"sort"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/rep"
+)
+
+/*
+Explain performs a dry run of Schedule. It places the auction request on copies
+of the scheduler's cells, in the same order and with the same filters and
+scores as Schedule, and reports for every auction each cell that was
+considered, the stage that rejected it or its score, and the winner. Nothing is
+committed to the cells, and the scheduler and the auction request are left
+untouched. Explain always scores every cell and describes the greedy placement,
+even when the scheduler samples cells or has a solver.
+*/
+func (s *Scheduler) Explain(auctionRequest auctiontypes.AuctionRequest) auctiontypes.AuctionExplanation {
+	request := auctiontypes.AuctionRequest{
+		LRPs:  append([]auctiontypes.LRPAuction{}, auctionRequest.LRPs...),
+		Tasks: append([]auctiontypes.TaskAuction{}, auctionRequest.Tasks...),
+	}
+
+	if len(s.zones) == 0 {
+		return explainResults(failAll(request), nil)
+	}
+
+	dryRun := *s
+	dryRun.logger = s.logger.Session("explain")
+	dryRun.zones = copyZones(s.zones)
+	dryRun.explanation = newExplanation()
+	dryRun.solverBudget = 0
+	dryRun.sampleSize = 0
+
+	p := dryRun.place(request)
+
+	results := p.results
+	for _, lrpAuction := range p.successfulLRPs {
+		results.SuccessfulLRPs = append(results.SuccessfulLRPs, *lrpAuction)
+	}
+	for _, taskAuction := range p.successfulTasks {
+		results.SuccessfulTasks = append(results.SuccessfulTasks, *taskAuction)
+	}
+
+	return explainResults(results, dryRun.explanation)
+}
+
+func explainResults(results auctiontypes.AuctionResults, e *explanation) auctiontypes.AuctionExplanation {
+	explained := auctiontypes.AuctionExplanation{}
+
+	lrpAuctions := append(results.SuccessfulLRPs, results.FailedLRPs...)
+	sort.Sort(SortableLRPAuctions(lrpAuctions))
+	for _, lrpAuction := range lrpAuctions {
+		explained.LRPs = append(explained.LRPs, auctiontypes.LRPExplanation{
+			LRPAuction: lrpAuction,
+			Cells:      e.forLRP(lrpAuction.Identifier()).explanations(),
+		})
+	}
+
+	taskAuctions := append(results.SuccessfulTasks, results.FailedTasks...)
+	sort.Sort(SortableTaskAuctions(taskAuctions))
+	for _, taskAuction := range taskAuctions {
+		explained.Tasks = append(explained.Tasks, auctiontypes.TaskExplanation{
+			TaskAuction: taskAuction,
+			Cells:       e.forTask(taskAuction.Identifier()).explanations(),
+		})
+	}
+
+	return explained
+}
+
+func copyZones(zones map[string]Zone) map[string]Zone {
+	copied := make(map[string]Zone, len(zones))
+	for name, zone := range zones {
+		cells := make(Zone, 0, len(zone))
+		for _, cell := range zone {
+			state := cell.state
+			state.LRPs = append([]rep.LRP{}, cell.state.LRPs...)
+			state.Tasks = append([]rep.Task{}, cell.state.Tasks...)
+			copied := NewCell(cell.logger, cell.Guid, cell.client, state)
+			copied.topology = cell.topology
+			cells = append(cells, copied)
+		}
+		copied[name] = cells
+	}
+	return copied
+}
+
+// explanation collects the cells considered for every auction. A nil
+// explanation records nothing, so scheduling only pays for it while
+// explaining.
+type explanation struct {
+	lrps  map[string]*cellExplainer
+	tasks map[string]*cellExplainer
+}
+
+func newExplanation() *explanation {
+	return &explanation{
+		lrps:  map[string]*cellExplainer{},
+		tasks: map[string]*cellExplainer{},
+	}
+}
+
+func (e *explanation) forLRP(identifier string) *cellExplainer {
+	if e == nil {
+		return nil
+	}
+	if e.lrps[identifier] == nil {
+		e.lrps[identifier] = &cellExplainer{index: map[string]int{}}
+	}
+	return e.lrps[identifier]
+}
+
+func (e *explanation) forTask(identifier string) *cellExplainer {
+	if e == nil {
+		return nil
+	}
+	if e.tasks[identifier] == nil {
+		e.tasks[identifier] = &cellExplainer{index: map[string]int{}}
+	}
+	return e.tasks[identifier]
+}
+
+type cellExplainer struct {
+	cells  []auctiontypes.CellExplanation
+	scored []bool
+	index  map[string]int
+}
+
+func (c *cellExplainer) filter(zones map[string]Zone, pc rep.PlacementConstraint) {
+	if c == nil {
+		return
+	}
+
+	for _, zone := range zones {
+		for _, cell := range zone {
+			switch {
+			case !cell.MatchRootFS(pc.RootFs):
+				c.reject(cell, auctiontypes.FilterStageRootFS, auctiontypes.ErrorCellMismatch)
+			case !cell.MatchVolumeDrivers(pc.VolumeDrivers):
+				c.reject(cell, auctiontypes.FilterStageVolumeDrivers, auctiontypes.ErrorVolumeDriverMismatch)
+			case !cell.MatchPlacementTags(pc.PlacementTags):
+				c.reject(cell, auctiontypes.FilterStagePlacementTags, auctiontypes.NewPlacementTagMismatchError(pc.PlacementTags))
+			default:
+				c.entry(cell)
+			}
+		}
+	}
+}
+
+func (c *cellExplainer) rejectAll(zones map[string]Zone, stage auctiontypes.FilterStage, err error) {
+	if c == nil {
+		return
+	}
+
+	for _, zone := range zones {
+		for _, cell := range zone {
+			c.reject(cell, stage, err)
+		}
+	}
+}
+
+// rejectZone rejects the cells of the zone, except those kept.
+func (c *cellExplainer) rejectZone(zone Zone, stage auctiontypes.FilterStage, err error, kept ...*Cell) {
+	if c == nil {
+		return
+	}
+
+	keep := map[*Cell]bool{}
+	for _, cell := range kept {
+		keep[cell] = true
+	}
+	for _, cell := range zone {
+		if !keep[cell] {
+			c.reject(cell, stage, err)
+		}
+	}
+}
+
+func (c *cellExplainer) reject(cell *Cell, stage auctiontypes.FilterStage, err error) {
+	if c == nil {
+		return
+	}
+
+	i := c.entry(cell)
+	c.cells[i].RejectedBy = stage
+	c.cells[i].Reason = err.Error()
+	c.scored[i] = true
+}
+
+func (c *cellExplainer) score(cell *Cell, score float64) {
+	if c == nil {
+		return
+	}
+
+	i := c.entry(cell)
+	c.cells[i].Score = score
+	c.scored[i] = true
+}
+
+func (c *cellExplainer) rejectUnscored(stage auctiontypes.FilterStage, err error) {
+	if c == nil {
+		return
+	}
+
+	for i := range c.cells {
+		if !c.scored[i] {
+			c.cells[i].RejectedBy = stage
+			c.cells[i].Reason = err.Error()
+			c.scored[i] = true
+		}
+	}
+}
+
+func (c *cellExplainer) entry(cell *Cell) int {
+	if i, ok := c.index[cell.Guid]; ok {
+		return i
+	}
+
+	c.index[cell.Guid] = len(c.cells)
+	c.cells = append(c.cells, auctiontypes.CellExplanation{CellID: cell.Guid, Zone: cell.state.Zone})
+	c.scored = append(c.scored, false)
+	return len(c.cells) - 1
+}
+
+func (c *cellExplainer) explanations() []auctiontypes.CellExplanation {
+	if c == nil {
+		return nil
+	}
+
+	cells := append([]auctiontypes.CellExplanation{}, c.cells...)
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Zone == cells[j].Zone {
+			return cells[i].CellID < cells[j].CellID
+		}
+		return cells[i].Zone < cells[j].Zone
+	})
+	return cells
+}
@@ -0,0 +1,220 @@
+package auctionrunner_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/workpool"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/rep/repfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Explain", func() {
+	var (
+		clients   map[string]*repfakes.FakeSimClient
+		zones     map[string]auctionrunner.Zone
+		clock     *fakeclock.FakeClock
+		workPool  *workpool.WorkPool
+		logger    *lagertest.TestLogger
+		scheduler *auctionrunner.Scheduler
+	)
+
+	cellFor := func(explanations []auctiontypes.CellExplanation, cellID string) auctiontypes.CellExplanation {
+		for _, explanation := range explanations {
+			if explanation.CellID == cellID {
+				return explanation
+			}
+		}
+		Fail("no explanation for " + cellID)
+		return auctiontypes.CellExplanation{}
+	}
+
+	BeforeEach(func() {
+		clock = fakeclock.NewFakeClock(time.Now())
+
+		var err error
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		logger = lagertest.NewTestLogger("explain")
+
+		clients = map[string]*repfakes.FakeSimClient{
+			"A-cell":       &repfakes.FakeSimClient{},
+			"B-cell":       &repfakes.FakeSimClient{},
+			"tagged-cell":  &repfakes.FakeSimClient{},
+			"windows-cell": &repfakes.FakeSimClient{},
+			"full-cell":    &repfakes.FakeSimClient{},
+		}
+
+		zones = map[string]auctionrunner.Zone{
+			"A-zone": auctionrunner.Zone{
+				auctionrunner.NewCell(logger, "A-cell", clients["A-cell"], BuildCellState("A-cell", "A-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, []rep.LRP{
+					*BuildLRP("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 10, []string{}),
+				}, []string{}, []string{}, []string{}, 0)),
+				auctionrunner.NewCell(logger, "windows-cell", clients["windows-cell"], BuildCellState("windows-cell", "A-zone", 100, 100, 100, false, 0, windowsOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+			},
+			"B-zone": auctionrunner.Zone{
+				auctionrunner.NewCell(logger, "B-cell", clients["B-cell"], BuildCellState("B-cell", "B-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+				auctionrunner.NewCell(logger, "tagged-cell", clients["tagged-cell"], BuildCellState("tagged-cell", "B-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, []rep.LRP{
+					*BuildLRP("pg-9", "domain", 0, linuxRootFSURL, 10, 10, 10, []string{}),
+				}, []string{}, []string{}, []string{"tag"}, 0)),
+				auctionrunner.NewCell(logger, "full-cell", clients["full-cell"], BuildCellState("full-cell", "B-zone", 10, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+			},
+		}
+
+		scheduler = auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0)
+	})
+
+	AfterEach(func() {
+		workPool.Stop()
+	})
+
+	Context("when there are no cells", func() {
+		It("explains every auction as failed without any cells", func() {
+			scheduler = auctionrunner.NewScheduler(workPool, map[string]auctionrunner.Zone{}, clock, logger, 0.0, 0)
+			lrpAuction := BuildLRPAuction("pg-1", "domain", 1, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{})
+
+			explanation := scheduler.Explain(auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{lrpAuction}})
+			Expect(explanation.LRPs).To(HaveLen(1))
+			Expect(explanation.LRPs[0].PlacementError).To(Equal(auctiontypes.ErrorCellCommunication.Error()))
+			Expect(explanation.LRPs[0].Cells).To(BeEmpty())
+		})
+	})
+
+	Describe("explaining an LRP auction", func() {
+		var explanation auctiontypes.AuctionExplanation
+		var lrpAuction auctiontypes.LRPAuction
+
+		BeforeEach(func() {
+			lrpAuction = BuildLRPAuction("pg-1", "domain", 1, linuxRootFSURL, 20, 10, 10, clock.Now(), nil, []string{})
+		})
+
+		JustBeforeEach(func() {
+			explanation = scheduler.Explain(auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{lrpAuction}})
+			Expect(explanation.LRPs).To(HaveLen(1))
+		})
+
+		It("reports the winner", func() {
+			Expect(explanation.LRPs[0].Winner).To(Equal("B-cell"))
+			Expect(explanation.LRPs[0].PlacementError).To(BeEmpty())
+		})
+
+		It("reports every cell with the stage that rejected it", func() {
+			cells := explanation.LRPs[0].Cells
+			Expect(cells).To(HaveLen(5))
+
+			Expect(cellFor(cells, "windows-cell").RejectedBy).To(Equal(auctiontypes.FilterStageRootFS))
+			Expect(cellFor(cells, "windows-cell").Reason).To(Equal(auctiontypes.ErrorCellMismatch.Error()))
+
+			Expect(cellFor(cells, "full-cell").RejectedBy).To(Equal(auctiontypes.FilterStageResources))
+			Expect(cellFor(cells, "full-cell").Reason).To(Equal("insufficient resources: memory"))
+
+			Expect(cellFor(cells, "A-cell").RejectedBy).To(Equal(auctiontypes.FilterStageZoneBalance))
+
+			Expect(cellFor(cells, "B-cell").RejectedBy).To(BeEmpty())
+			Expect(cellFor(cells, "tagged-cell").RejectedBy).To(BeEmpty())
+		})
+
+		It("reports the scores of the cells it scored", func() {
+			cells := explanation.LRPs[0].Cells
+			Expect(cellFor(cells, "B-cell").Score).To(BeNumerically(">", 0))
+			Expect(cellFor(cells, "B-cell").Score).To(BeNumerically("<", cellFor(cells, "tagged-cell").Score))
+		})
+
+		It("does not commit any work to the cells", func() {
+			for _, client := range clients {
+				Expect(client.PerformCallCount()).To(BeZero())
+			}
+		})
+
+		It("leaves the scheduler able to schedule the same work", func() {
+			results := scheduler.Schedule(auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{lrpAuction}})
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Winner).To(Equal("B-cell"))
+		})
+
+		Context("when the LRP requires a placement tag", func() {
+			BeforeEach(func() {
+				lrpAuction = BuildLRPAuction("pg-2", "domain", 0, linuxRootFSURL, 20, 10, 10, clock.Now(), nil, []string{"tag"})
+			})
+
+			It("reports the cells without the tag as rejected by placement tags", func() {
+				cells := explanation.LRPs[0].Cells
+				Expect(cellFor(cells, "A-cell").RejectedBy).To(Equal(auctiontypes.FilterStagePlacementTags))
+				Expect(cellFor(cells, "B-cell").RejectedBy).To(Equal(auctiontypes.FilterStagePlacementTags))
+				Expect(explanation.LRPs[0].Winner).To(Equal("tagged-cell"))
+			})
+		})
+
+		Context("when no cell fits", func() {
+			BeforeEach(func() {
+				lrpAuction = BuildLRPAuction("pg-2", "domain", 0, linuxRootFSURL, 1000, 10, 10, clock.Now(), nil, []string{})
+			})
+
+			It("reports the placement error", func() {
+				Expect(explanation.LRPs[0].Winner).To(BeEmpty())
+				Expect(explanation.LRPs[0].PlacementError).To(Equal("insufficient resources: memory"))
+			})
+		})
+	})
+
+	Describe("explaining several auctions", func() {
+		It("reflects earlier placements in later auctions", func() {
+			explanation := scheduler.Explain(auctiontypes.AuctionRequest{
+				Tasks: []auctiontypes.TaskAuction{
+					BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 80, 10, 10, []string{}, []string{}), clock.Now()),
+					BuildTaskAuction(BuildTask("tg-2", "domain", linuxRootFSURL, 70, 10, 10, []string{}, []string{}), clock.Now()),
+				},
+			})
+
+			Expect(explanation.Tasks).To(HaveLen(2))
+			Expect(explanation.Tasks[0].TaskGuid).To(Equal("tg-1"))
+			Expect(explanation.Tasks[0].Winner).To(Equal("B-cell"))
+
+			Expect(explanation.Tasks[1].Winner).NotTo(BeEmpty())
+			Expect(explanation.Tasks[1].Winner).NotTo(Equal("B-cell"))
+			Expect(cellFor(explanation.Tasks[1].Cells, "B-cell").RejectedBy).To(Equal(auctiontypes.FilterStageResources))
+		})
+
+		It("does not modify the auction request", func() {
+			lrpAuctions := []auctiontypes.LRPAuction{
+				BuildLRPAuction("pg-2", "domain", 1, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{}),
+				BuildLRPAuction("pg-3", "domain", 0, linuxRootFSURL, 1000, 10, 10, clock.Now(), nil, []string{}),
+			}
+
+			scheduler.Explain(auctiontypes.AuctionRequest{LRPs: lrpAuctions})
+			Expect(lrpAuctions[0].ProcessGuid).To(Equal("pg-2"))
+			Expect(lrpAuctions[1].PlacementError).To(BeEmpty())
+		})
+
+		Context("when the inflight limit is reached", func() {
+			BeforeEach(func() {
+				scheduler = auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 1)
+			})
+
+			It("reports every cell as rejected by the inflight limit", func() {
+				explanation := scheduler.Explain(auctiontypes.AuctionRequest{
+					LRPs: []auctiontypes.LRPAuction{
+						BuildLRPAuction("pg-2", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{}),
+						BuildLRPAuction("pg-3", "domain", 0, linuxRootFSURL, 5, 10, 10, clock.Now(), nil, []string{}),
+					},
+				})
+
+				Expect(explanation.LRPs).To(HaveLen(2))
+				limited := explanation.LRPs[1]
+				Expect(limited.PlacementError).To(Equal(auctiontypes.ErrorExceededInflightCreation.Error()))
+				Expect(limited.Cells).To(HaveLen(5))
+				for _, cell := range limited.Cells {
+					Expect(cell.RejectedBy).To(Equal(auctiontypes.FilterStageInflightLimit))
+				}
+			})
+		})
+	})
+})
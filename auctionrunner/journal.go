@@ -0,0 +1,323 @@
+package auctionrunner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/lager"
+)
+
+// WithJournal records the auctions added to the batch, and the auctions
+// completed or cancelled, in journal, and queues the auctions the journal
+// still holds when the batch is created. Drained auctions stay in the journal
+// until they are completed, so that a crash during an auction, or a shutdown
+// that discards the queued auctions, does not lose them. Auctions handed back
+// on shutdown, and duplicates merged while deduping, leave the journal.
+func WithJournal(journal auctiontypes.BatchJournal) BatchOption {
+	return func(b *Batch) {
+		b.journal = journal
+	}
+}
+
+// Completed records that the drained auctions have been auctioned, so that
+// the journal does not queue them again after a restart.
+func (b *Batch) Completed(lrpAuctions []auctiontypes.LRPAuction, taskAuctions []auctiontypes.TaskAuction) {
+	if b.journal == nil || (len(lrpAuctions) == 0 && len(taskAuctions) == 0) {
+		return
+	}
+	b.journal.Drained(lrpAuctions, taskAuctions)
+}
+
+func (b *Batch) replay() {
+	lrpAuctions, taskAuctions := b.journal.Pending()
+	if len(lrpAuctions) == 0 && len(taskAuctions) == 0 {
+		return
+	}
+
+	b.lock.Lock()
+	b.lrpAuctions = append(b.lrpAuctions, lrpAuctions...)
+	b.taskAuctions = append(b.taskAuctions, taskAuctions...)
+	b.claimToHaveWork()
+	b.lock.Unlock()
+}
+
+// A FileJournal is a BatchJournal kept in an append-only file with one JSON
+// record per line. Every record is synced to disk before it is acknowledged.
+type FileJournal struct {
+	logger       lager.Logger
+	path         string
+	compactAfter int
+
+	lock         *sync.Mutex
+	file         *os.File
+	records      int
+	lastID       uint64
+	lrpAuctions  []auctiontypes.LRPAuction
+	taskAuctions []auctiontypes.TaskAuction
+}
+
+type journalRecord struct {
+	LRPs    []auctiontypes.LRPAuction  `json:"lrps,omitempty"`
+	Tasks   []auctiontypes.TaskAuction `json:"tasks,omitempty"`
+	Drained []uint64                   `json:"drained,omitempty"`
+}
+
+// NewFileJournal opens the journal at path, creating it if it does not exist,
+// and reads the auctions it still holds. The journal is compacted to only
+// those auctions when it is opened and after every compactAfter records; a
+// compactAfter of 0 only compacts on open. A record torn by a crash while it
+// was written is dropped.
+func NewFileJournal(logger lager.Logger, path string, compactAfter int) (*FileJournal, error) {
+	j := &FileJournal{
+		logger:       logger.Session("journal", lager.Data{"path": path}),
+		path:         path,
+		compactAfter: compactAfter,
+		lock:         &sync.Mutex{},
+		lrpAuctions:  []auctiontypes.LRPAuction{},
+		taskAuctions: []auctiontypes.TaskAuction{},
+	}
+
+	err := j.read()
+	if err != nil {
+		return nil, err
+	}
+
+	err = j.compact()
+	if err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+func (j *FileJournal) Pending() ([]auctiontypes.LRPAuction, []auctiontypes.TaskAuction) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	lrpAuctions := append([]auctiontypes.LRPAuction{}, j.lrpAuctions...)
+	taskAuctions := append([]auctiontypes.TaskAuction{}, j.taskAuctions...)
+	return lrpAuctions, taskAuctions
+}
+
+// Added sets the JournalID of the given auctions in place.
+func (j *FileJournal) Added(lrpAuctions []auctiontypes.LRPAuction, taskAuctions []auctiontypes.TaskAuction) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	for i := range lrpAuctions {
+		j.lastID++
+		lrpAuctions[i].JournalID = j.lastID
+	}
+	for i := range taskAuctions {
+		j.lastID++
+		taskAuctions[i].JournalID = j.lastID
+	}
+
+	err := j.append(journalRecord{LRPs: lrpAuctions, Tasks: taskAuctions})
+	if err != nil {
+		j.logger.Error("failed-to-record-added-auctions", err)
+		return err
+	}
+
+	j.lrpAuctions = append(j.lrpAuctions, lrpAuctions...)
+	j.taskAuctions = append(j.taskAuctions, taskAuctions...)
+	j.compactIfDue()
+	return nil
+}
+
+// Drained only logs a failure to record the drained auctions; at worst they
+// are auctioned again after a restart.
+func (j *FileJournal) Drained(lrpAuctions []auctiontypes.LRPAuction, taskAuctions []auctiontypes.TaskAuction) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	record := journalRecord{}
+	for i := range lrpAuctions {
+		if lrpAuctions[i].JournalID != 0 {
+			record.Drained = append(record.Drained, lrpAuctions[i].JournalID)
+		}
+	}
+	for i := range taskAuctions {
+		if taskAuctions[i].JournalID != 0 {
+			record.Drained = append(record.Drained, taskAuctions[i].JournalID)
+		}
+	}
+	if len(record.Drained) == 0 {
+		return
+	}
+	j.drain(record)
+
+	err := j.append(record)
+	if err != nil {
+		j.logger.Error("failed-to-record-drained-auctions", err)
+		return
+	}
+	j.compactIfDue()
+}
+
+func (j *FileJournal) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.file.Close()
+}
+
+func (j *FileJournal) read() error {
+	file, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				j.logger.Info("dropped-torn-record")
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		record := journalRecord{}
+		err = json.Unmarshal(line, &record)
+		if err != nil {
+			return err
+		}
+		for i := range record.LRPs {
+			j.lastID = maxID(j.lastID, record.LRPs[i].JournalID)
+		}
+		for i := range record.Tasks {
+			j.lastID = maxID(j.lastID, record.Tasks[i].JournalID)
+		}
+		j.lrpAuctions = append(j.lrpAuctions, record.LRPs...)
+		j.taskAuctions = append(j.taskAuctions, record.Tasks...)
+		j.drain(record)
+	}
+}
+
+func maxID(a, b uint64) uint64 {
+	if b > a {
+		return b
+	}
+	return a
+}
+
+func (j *FileJournal) drain(record journalRecord) {
+	if len(record.Drained) == 0 {
+		return
+	}
+
+	drained := map[uint64]struct{}{}
+	for _, id := range record.Drained {
+		drained[id] = struct{}{}
+	}
+
+	lrpAuctions := j.lrpAuctions[:0]
+	for _, lrpAuction := range j.lrpAuctions {
+		if _, ok := drained[lrpAuction.JournalID]; !ok {
+			lrpAuctions = append(lrpAuctions, lrpAuction)
+		}
+	}
+	j.lrpAuctions = lrpAuctions
+
+	taskAuctions := j.taskAuctions[:0]
+	for _, taskAuction := range j.taskAuctions {
+		if _, ok := drained[taskAuction.JournalID]; !ok {
+			taskAuctions = append(taskAuctions, taskAuction)
+		}
+	}
+	j.taskAuctions = taskAuctions
+}
+
+func (j *FileJournal) append(record journalRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = j.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	err = j.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	j.records++
+	return nil
+}
+
+func (j *FileJournal) compactIfDue() {
+	if j.compactAfter == 0 || j.records < j.compactAfter {
+		return
+	}
+
+	err := j.compact()
+	if err != nil {
+		j.logger.Error("failed-to-compact", err)
+	}
+}
+
+// compact replaces the journal with a single record of the auctions it still
+// holds.
+func (j *FileJournal) compact() error {
+	tmpPath := j.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if len(j.lrpAuctions) > 0 || len(j.taskAuctions) > 0 {
+		line, err := json.Marshal(journalRecord{LRPs: j.lrpAuctions, Tasks: j.taskAuctions})
+		if err == nil {
+			_, err = tmp.Write(append(line, '\n'))
+		}
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, j.path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file = file
+	j.records = 0
+
+	j.logger.Debug("compacted", lager.Data{
+		"lrp-start-auctions": len(j.lrpAuctions),
+		"task-auctions":      len(j.taskAuctions),
+	})
+	return nil
+}
@@ -0,0 +1,316 @@
+package auctionrunner_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/workpool"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/auction/auctiontypes/fakes"
+	"code.cloudfoundry.org/auctioneer"
+	"code.cloudfoundry.org/rep/repfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Journal", func() {
+	var (
+		dir     string
+		path    string
+		logger  *lagertest.TestLogger
+		clock   *fakeclock.FakeClock
+		journal *auctionrunner.FileJournal
+		batch   *auctionrunner.Batch
+		queued  time.Time
+	)
+
+	// restart opens the journal again without closing it, as after a crash.
+	restart := func(compactAfter int) {
+		var err error
+		journal, err = auctionrunner.NewFileJournal(logger, path, compactAfter)
+		Expect(err).NotTo(HaveOccurred())
+		batch = auctionrunner.NewBatch(clock, auctionrunner.WithJournal(journal))
+	}
+
+	journalLines := func() []string {
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		return strings.Split(strings.TrimSpace(string(contents)), "\n")
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "journal")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "batch.journal")
+
+		logger = lagertest.NewTestLogger("journal")
+		clock = fakeclock.NewFakeClock(time.Now())
+		queued = clock.Now()
+		restart(0)
+
+		Expect(batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+			BuildLRPStartRequest("pg-1", "domain", []int{0, 1}, "linux", 10, 10, 10, []string{}, []string{}),
+		})).To(Succeed())
+		Expect(batch.AddTasks([]auctioneer.TaskStartRequest{
+			BuildTaskStartRequest("tg-1", "domain", "linux", 10, 10, 10),
+		})).To(Succeed())
+		clock.Increment(time.Minute)
+	})
+
+	AfterEach(func() {
+		journal.Close()
+		os.RemoveAll(dir)
+	})
+
+	Context("when the process crashes before the auctions are drained", func() {
+		BeforeEach(func() {
+			restart(0)
+		})
+
+		It("queues the auctions again", func() {
+			Expect(batch.HasWork).To(Receive())
+
+			lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(HaveLen(2))
+			Expect(lrpAuctions[0].ProcessGuid).To(Equal("pg-1"))
+			Expect(lrpAuctions[0].Resource).To(Equal(lrpAuctions[1].Resource))
+			Expect(taskAuctions).To(HaveLen(1))
+			Expect(taskAuctions[0].TaskGuid).To(Equal("tg-1"))
+		})
+
+		It("keeps the time the auctions were first queued", func() {
+			lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+			Expect(lrpAuctions[0].QueueTime.Equal(queued)).To(BeTrue())
+			Expect(taskAuctions[0].QueueTime.Equal(queued)).To(BeTrue())
+		})
+	})
+
+	Context("when the process crashes during an auction", func() {
+		BeforeEach(func() {
+			batch.DedupeAndDrain()
+			restart(0)
+		})
+
+		It("queues the drained auctions again", func() {
+			lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(HaveLen(2))
+			Expect(taskAuctions).To(HaveLen(1))
+		})
+	})
+
+	Context("when the process crashes after the auctions are completed", func() {
+		BeforeEach(func() {
+			batch.Completed(batch.DedupeAndDrain())
+			Expect(batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-2", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+			})).To(Succeed())
+			restart(0)
+		})
+
+		It("queues only the auctions added since", func() {
+			lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(HaveLen(1))
+			Expect(lrpAuctions[0].ProcessGuid).To(Equal("pg-2"))
+			Expect(taskAuctions).To(BeEmpty())
+		})
+	})
+
+	Context("when the auctions are queued again during an auction", func() {
+		BeforeEach(func() {
+			lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+			Expect(batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+			})).To(Succeed())
+			batch.Completed(lrpAuctions, taskAuctions)
+			restart(0)
+		})
+
+		It("keeps the auctions queued again after a restart", func() {
+			lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(HaveLen(1))
+			Expect(lrpAuctions[0].ProcessGuid).To(Equal("pg-1"))
+			Expect(lrpAuctions[0].Index).To(BeEquivalentTo(0))
+			Expect(taskAuctions).To(BeEmpty())
+		})
+	})
+
+	Context("when duplicate auctions are merged", func() {
+		BeforeEach(func() {
+			Expect(batch.AddLRPStarts([]auctioneer.LRPStartRequest{
+				BuildLRPStartRequest("pg-1", "domain", []int{0}, "linux", 10, 10, 10, []string{}, []string{}),
+			})).To(Succeed())
+			batch.Completed(batch.DedupeAndDrain())
+			restart(0)
+		})
+
+		It("forgets the duplicates once the auctions are completed", func() {
+			lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(BeEmpty())
+			Expect(taskAuctions).To(BeEmpty())
+		})
+	})
+
+	Context("when the process crashes while writing a record", func() {
+		BeforeEach(func() {
+			file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = file.WriteString(`{"drained":[1`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Close()).To(Succeed())
+
+			restart(0)
+		})
+
+		It("drops the torn record", func() {
+			lrpAuctions, taskAuctions := batch.DedupeAndDrain()
+			Expect(lrpAuctions).To(HaveLen(2))
+			Expect(taskAuctions).To(HaveLen(1))
+		})
+	})
+
+	Context("when the journal is corrupt", func() {
+		It("fails to open", func() {
+			Expect(ioutil.WriteFile(path, []byte("garbage\n"), 0600)).To(Succeed())
+			_, err := auctionrunner.NewFileJournal(logger, path, 0)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("compaction", func() {
+		BeforeEach(func() {
+			restart(2)
+		})
+
+		It("compacts the journal when it is opened", func() {
+			Expect(journalLines()).To(HaveLen(1))
+		})
+
+		It("compacts the journal after enough records", func() {
+			batch.Completed(batch.DedupeAndDrain())
+			Expect(journalLines()).To(HaveLen(2))
+
+			Expect(batch.AddTasks([]auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("tg-2", "domain", "linux", 10, 10, 10),
+			})).To(Succeed())
+			Expect(journalLines()).To(HaveLen(1))
+
+			restart(2)
+			_, taskAuctions := batch.DedupeAndDrain()
+			Expect(taskAuctions).To(HaveLen(1))
+			Expect(taskAuctions[0].TaskGuid).To(Equal("tg-2"))
+		})
+	})
+})
+
+var _ = Describe("Journalling runner", func() {
+	var (
+		dir      string
+		path     string
+		logger   *lagertest.TestLogger
+		clock    *fakeclock.FakeClock
+		journal  *auctionrunner.FileJournal
+		delegate *handBackDelegate
+		workPool *workpool.WorkPool
+	)
+
+	// run schedules an LRP and a task, lets the runner auction them unless a
+	// batching window holds them back, and stops it.
+	run := func(mode auctionrunner.ShutdownMode, batchOptions ...auctionrunner.BatchOption) {
+		runner := auctionrunner.New(
+			logger,
+			delegate,
+			&fakes.FakeAuctionMetricEmitterDelegate{},
+			clock,
+			workPool,
+			0.0,
+			0,
+			auctionrunner.WithBatchOptions(append(batchOptions, auctionrunner.WithJournal(journal))...),
+			auctionrunner.WithShutdownMode(mode),
+		)
+		Expect(runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{
+			BuildLRPStartRequest("pg-1", "domain", []int{0}, linuxRootFSURL, 10, 10, 10, []string{}, []string{}),
+		})).To(Succeed())
+		Expect(runner.ScheduleTasksForAuctions([]auctioneer.TaskStartRequest{
+			auctioneer.NewTaskStartRequest(*BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{})),
+		})).To(Succeed())
+
+		signals := make(chan os.Signal)
+		runErr := make(chan error, 1)
+		go func() {
+			runErr <- runner.Run(signals, make(chan struct{}))
+		}()
+		if len(batchOptions) == 0 {
+			Eventually(delegate.Completed).Should(HaveLen(1))
+		}
+		signals <- os.Interrupt
+		Eventually(runErr).Should(Receive(BeNil()))
+	}
+
+	pendingAfterRestart := func() ([]auctiontypes.LRPAuction, []auctiontypes.TaskAuction) {
+		Expect(journal.Close()).To(Succeed())
+		var err error
+		journal, err = auctionrunner.NewFileJournal(logger, path, 0)
+		Expect(err).NotTo(HaveOccurred())
+		return journal.Pending()
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "journal")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "batch.journal")
+
+		logger = lagertest.NewTestLogger("journal")
+		clock = fakeclock.NewFakeClock(time.Now())
+		journal, err = auctionrunner.NewFileJournal(logger, path, 0)
+		Expect(err).NotTo(HaveOccurred())
+
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		client := &repfakes.FakeSimClient{}
+		client.StateReturns(BuildCellState("A-cell", "A-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0), nil)
+		delegate = &handBackDelegate{runnerDelegate: &runnerDelegate{client: client}}
+	})
+
+	AfterEach(func() {
+		workPool.Stop()
+		journal.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("forgets auctions once they are completed", func() {
+		run(auctionrunner.ShutdownDiscard)
+
+		lrpAuctions, taskAuctions := pendingAfterRestart()
+		Expect(lrpAuctions).To(BeEmpty())
+		Expect(taskAuctions).To(BeEmpty())
+	})
+
+	It("keeps the auctions discarded on shutdown", func() {
+		run(auctionrunner.ShutdownDiscard, auctionrunner.WithBatchWindow(time.Minute, 0))
+
+		lrpAuctions, taskAuctions := pendingAfterRestart()
+		Expect(lrpAuctions).To(HaveLen(1))
+		Expect(taskAuctions).To(HaveLen(1))
+	})
+
+	It("forgets the auctions handed back on shutdown", func() {
+		run(auctionrunner.ShutdownHandBack, auctionrunner.WithBatchWindow(time.Minute, 0))
+		Expect(delegate.handedBackLRPs).To(HaveLen(1))
+		Expect(delegate.handedBackTasks).To(HaveLen(1))
+
+		lrpAuctions, taskAuctions := pendingAfterRestart()
+		Expect(lrpAuctions).To(BeEmpty())
+		Expect(taskAuctions).To(BeEmpty())
+	})
+})
@@ -0,0 +1,129 @@
+package auctionrunner
+
+import (
+	"sort"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/rep"
+)
+
+// WithPreemption lets an LRP that fits on no cell preempt tasks of a lower
+// priority, as reported by the prioritizer, that are running on a cell.
+func WithPreemption(prioritizer auctiontypes.Prioritizer) SchedulerOption {
+	return func(s *Scheduler) {
+		s.preemption = prioritizer
+	}
+}
+
+// preemptForLRP looks for the cell on which the fewest lower priority tasks
+// have to be preempted for the LRP to fit. The victims' resources are freed and
+// room is held for the LRP, but the LRP is not committed: it can only be placed
+// once the caller has cancelled the victims, so it fails with
+// ErrorPreemptionPending and is auctioned again.
+func (s *Scheduler) preemptForLRP(lrpAuction *auctiontypes.LRPAuction, zones []lrpByZone) bool {
+	var victimCell *Cell
+	var victims []rep.Task
+
+	for _, lrpByZone := range zones {
+		for _, cell := range lrpByZone.zone {
+			cellVictims := s.victimsFor(cell, lrpAuction)
+			if cellVictims == nil {
+				continue
+			}
+
+			if victimCell == nil || s.fewerVictims(cellVictims, victims) {
+				victimCell = cell
+				victims = cellVictims
+			}
+		}
+	}
+
+	if victimCell == nil {
+		return false
+	}
+
+	victimCell.holdForPreemption(&lrpAuction.LRP, victims)
+	for _, victim := range victims {
+		s.logger.Info("task-preempted", lager.Data{"task-guid": victim.TaskGuid, "cell-guid": victimCell.Guid, "lrp-guid": lrpAuction.Identifier()})
+		s.preempted = append(s.preempted, auctiontypes.PreemptedTask{
+			Task:        victim,
+			CellID:      victimCell.Guid,
+			PreemptedBy: lrpAuction.Identifier(),
+		})
+	}
+	return true
+}
+
+// victimsFor returns a minimal set of tasks on the cell with a lower priority
+// than the LRP whose removal makes room for it, or nil if there is none.
+// Tasks are taken lowest priority and least recently started first, and tasks
+// that turn out not to be needed are given back, latest taken first.
+func (s *Scheduler) victimsFor(cell *Cell, lrpAuction *auctiontypes.LRPAuction) []rep.Task {
+	reserved := map[string]struct{}{}
+	for _, task := range cell.workToCommit.Tasks {
+		reserved[task.TaskGuid] = struct{}{}
+	}
+
+	candidates := []rep.Task{}
+	for _, task := range cell.state.Tasks {
+		if _, ok := reserved[task.TaskGuid]; ok {
+			continue
+		}
+		if s.preemption.TaskPriority(&task) < lrpAuction.Priority {
+			candidates = append(candidates, task)
+		}
+	}
+	s.sortVictims(candidates)
+
+	resource := cell.proxiedResource(&lrpAuction.LRP)
+	victims := []rep.Task{}
+	for _, candidate := range candidates {
+		if cell.fitsWithout(&resource, victims) {
+			break
+		}
+		victims = append(victims, candidate)
+	}
+	if !cell.fitsWithout(&resource, victims) {
+		return nil
+	}
+
+	for i := len(victims) - 1; i >= 0; i-- {
+		without := append(append([]rep.Task{}, victims[:i]...), victims[i+1:]...)
+		if cell.fitsWithout(&resource, without) {
+			victims = without
+		}
+	}
+	return victims
+}
+
+func (s *Scheduler) sortVictims(tasks []rep.Task) {
+	startTimes, _ := s.preemption.(auctiontypes.TaskStartTimeReporter)
+
+	sort.SliceStable(tasks, func(i, j int) bool {
+		pi, pj := s.preemption.TaskPriority(&tasks[i]), s.preemption.TaskPriority(&tasks[j])
+		if pi != pj || startTimes == nil {
+			return pi < pj
+		}
+		return startTimes.TaskStartedAt(&tasks[i]).Before(startTimes.TaskStartedAt(&tasks[j]))
+	})
+}
+
+// fewerVictims reports whether preempting victims is preferable to preempting
+// others: fewer tasks, then tasks of a lower priority.
+func (s *Scheduler) fewerVictims(victims, others []rep.Task) bool {
+	if len(victims) != len(others) {
+		return len(victims) < len(others)
+	}
+	return s.highestTaskPriority(victims) < s.highestTaskPriority(others)
+}
+
+func (s *Scheduler) highestTaskPriority(tasks []rep.Task) auctiontypes.Priority {
+	var highest auctiontypes.Priority
+	for i := range tasks {
+		if priority := s.preemption.TaskPriority(&tasks[i]); i == 0 || priority > highest {
+			highest = priority
+		}
+	}
+	return highest
+}
@@ -0,0 +1,215 @@
+package auctionrunner_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/workpool"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/rep/repfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type startTimePrioritizer struct {
+	testPrioritizer
+	startedAt map[string]time.Time
+}
+
+func (p startTimePrioritizer) TaskStartedAt(task *rep.Task) time.Time {
+	return p.startedAt[task.TaskGuid]
+}
+
+var _ = Describe("Preemption", func() {
+	var (
+		clients     map[string]*repfakes.FakeSimClient
+		zones       map[string]auctionrunner.Zone
+		clock       *fakeclock.FakeClock
+		workPool    *workpool.WorkPool
+		logger      *lagertest.TestLogger
+		prioritizer auctiontypes.Prioritizer
+		options     []auctionrunner.SchedulerOption
+		lrpAuctions []auctiontypes.LRPAuction
+		results     auctiontypes.AuctionResults
+	)
+
+	withTasks := func(state rep.CellState, tasks ...*rep.Task) rep.CellState {
+		for _, task := range tasks {
+			state.AvailableResources.Subtract(&task.Resource)
+			state.Tasks = append(state.Tasks, *task)
+		}
+		return state
+	}
+
+	buildCell := func(cellID, zone string, memoryMB int32, tasks ...*rep.Task) *auctionrunner.Cell {
+		clients[cellID] = &repfakes.FakeSimClient{}
+		state := BuildCellState(cellID, zone, memoryMB, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)
+		return auctionrunner.NewCell(logger, cellID, clients[cellID], withTasks(state, tasks...))
+	}
+
+	lrpAuction := func(processGuid string, memoryMB int32) auctiontypes.LRPAuction {
+		auction := BuildLRPAuction(processGuid, "domain", 0, linuxRootFSURL, memoryMB, 10, 10, clock.Now(), nil, []string{})
+		auction.Priority = prioritizer.LRPPriority(&auction.LRP)
+		return auction
+	}
+
+	BeforeEach(func() {
+		clock = fakeclock.NewFakeClock(time.Now())
+
+		var err error
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		logger = lagertest.NewTestLogger("preemption")
+		clients = map[string]*repfakes.FakeSimClient{}
+
+		prioritizer = testPrioritizer{"pg-high": 10, "tg-high": 10}
+		options = []auctionrunner.SchedulerOption{auctionrunner.WithPreemption(prioritizer)}
+
+		zones = map[string]auctionrunner.Zone{
+			"A-zone": auctionrunner.Zone{
+				buildCell("A-cell", "A-zone", 100,
+					BuildTask("tg-1", "domain", linuxRootFSURL, 20, 10, 10, []string{}, []string{}),
+					BuildTask("tg-2", "domain", linuxRootFSURL, 20, 10, 10, []string{}, []string{}),
+					BuildTask("tg-3", "domain", linuxRootFSURL, 20, 10, 10, []string{}, []string{}),
+					BuildTask("tg-4", "domain", linuxRootFSURL, 20, 10, 10, []string{}, []string{}),
+				),
+			},
+			"B-zone": auctionrunner.Zone{
+				buildCell("B-cell", "B-zone", 100,
+					BuildTask("tg-5", "domain", linuxRootFSURL, 70, 10, 10, []string{}, []string{}),
+				),
+			},
+		}
+
+		lrpAuctions = []auctiontypes.LRPAuction{lrpAuction("pg-high", 50)}
+	})
+
+	JustBeforeEach(func() {
+		scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0, options...)
+		results = scheduler.Schedule(auctiontypes.AuctionRequest{LRPs: lrpAuctions})
+	})
+
+	AfterEach(func() {
+		workPool.Stop()
+	})
+
+	It("preempts the fewest lower priority tasks that make room for the LRP", func() {
+		Expect(results.PreemptedTasks).To(HaveLen(1))
+		Expect(results.PreemptedTasks[0].TaskGuid).To(Equal("tg-5"))
+		Expect(results.PreemptedTasks[0].CellID).To(Equal("B-cell"))
+		Expect(results.PreemptedTasks[0].PreemptedBy).To(Equal("pg-high.0"))
+	})
+
+	It("fails the LRP until the preempted tasks have been cancelled", func() {
+		Expect(results.SuccessfulLRPs).To(BeEmpty())
+		Expect(results.FailedLRPs).To(HaveLen(1))
+		Expect(results.FailedLRPs[0].PlacementError).To(Equal(auctiontypes.ErrorPreemptionPending.Error()))
+
+		for _, client := range clients {
+			Expect(client.PerformCallCount()).To(BeZero())
+		}
+	})
+
+	Context("when later work would fit in the room held for the LRP", func() {
+		BeforeEach(func() {
+			lrpAuctions = append(lrpAuctions, lrpAuction("pg-low", 55))
+		})
+
+		It("does not place it there", func() {
+			Expect(results.SuccessfulLRPs).To(BeEmpty())
+			Expect(results.FailedLRPs).To(HaveLen(2))
+			for _, failed := range results.FailedLRPs {
+				if failed.ProcessGuid == "pg-low" {
+					Expect(failed.PlacementError).To(Equal("insufficient resources: memory"))
+				}
+			}
+			Expect(results.PreemptedTasks).To(HaveLen(1))
+		})
+	})
+
+	Context("when only tasks of the same or a higher priority are running", func() {
+		BeforeEach(func() {
+			zones = map[string]auctionrunner.Zone{
+				"A-zone": auctionrunner.Zone{
+					buildCell("A-cell", "A-zone", 100,
+						BuildTask("tg-high", "domain", linuxRootFSURL, 80, 10, 10, []string{}, []string{}),
+					),
+				},
+			}
+		})
+
+		It("fails the LRP without preempting anything", func() {
+			Expect(results.PreemptedTasks).To(BeEmpty())
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].PlacementError).To(Equal("insufficient resources: memory"))
+		})
+	})
+
+	Context("when a task of a higher priority is enough on its own", func() {
+		BeforeEach(func() {
+			prioritizer = testPrioritizer{"pg-high": 10, "tg-big": 5}
+			options = []auctionrunner.SchedulerOption{auctionrunner.WithPreemption(prioritizer)}
+			lrpAuctions = []auctiontypes.LRPAuction{lrpAuction("pg-high", 50)}
+
+			zones = map[string]auctionrunner.Zone{
+				"A-zone": auctionrunner.Zone{
+					buildCell("A-cell", "A-zone", 100,
+						BuildTask("tg-small", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}),
+						BuildTask("tg-big", "domain", linuxRootFSURL, 60, 10, 10, []string{}, []string{}),
+					),
+				},
+			}
+		})
+
+		It("does not preempt tasks that are not needed", func() {
+			Expect(results.PreemptedTasks).To(HaveLen(1))
+			Expect(results.PreemptedTasks[0].TaskGuid).To(Equal("tg-big"))
+		})
+	})
+
+	Context("when the prioritizer reports when tasks started", func() {
+		BeforeEach(func() {
+			prioritizer = startTimePrioritizer{
+				testPrioritizer: testPrioritizer{"pg-high": 10},
+				startedAt: map[string]time.Time{
+					"tg-1": clock.Now().Add(-time.Minute),
+					"tg-2": clock.Now().Add(-time.Hour),
+				},
+			}
+			options = []auctionrunner.SchedulerOption{auctionrunner.WithPreemption(prioritizer)}
+			lrpAuctions = []auctiontypes.LRPAuction{lrpAuction("pg-high", 50)}
+
+			zones = map[string]auctionrunner.Zone{
+				"A-zone": auctionrunner.Zone{
+					buildCell("A-cell", "A-zone", 100,
+						BuildTask("tg-1", "domain", linuxRootFSURL, 40, 10, 10, []string{}, []string{}),
+						BuildTask("tg-2", "domain", linuxRootFSURL, 40, 10, 10, []string{}, []string{}),
+					),
+				},
+			}
+		})
+
+		It("preempts the least recently started task", func() {
+			Expect(results.PreemptedTasks).To(HaveLen(1))
+			Expect(results.PreemptedTasks[0].TaskGuid).To(Equal("tg-2"))
+		})
+	})
+
+	Context("when preemption is not enabled", func() {
+		BeforeEach(func() {
+			options = nil
+		})
+
+		It("fails the LRP without preempting anything", func() {
+			Expect(results.PreemptedTasks).To(BeEmpty())
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.FailedLRPs[0].PlacementError).To(Equal("insufficient resources: memory"))
+		})
+	})
+})
@@ -0,0 +1,116 @@
+package auctionrunner
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/rep"
+)
+
+// WithCommitReconciliation fetches the state of every cell whose commit failed
+// with an error once the auction has been committed, and classifies the work
+// sent in the failed commit. Work the cell started is confirmed and reported as
+// successful; work the cell did not start is missing and fails with
+// ErrorWorkNotStarted. Work whose cell cannot be reached in time is unknown: the
+// cell may be running it, so it is logged and still reported as successful.
+func WithCommitReconciliation() SchedulerOption {
+	return func(s *Scheduler) {
+		s.reconcile = true
+	}
+}
+
+// reconcileCells returns the work that cells did not start after a failed
+// commit. The work of cells whose state could not be fetched before ctx was
+// done is logged as unknown.
+func (s *Scheduler) reconcileCells(ctx context.Context) []rep.Work {
+	type reconciled struct {
+		cell        *Cell
+		missingWork rep.Work
+		ok          bool
+	}
+
+	unconfirmedCells := []*Cell{}
+	for _, cells := range s.zones {
+		for _, cell := range cells {
+			if len(cell.unconfirmedWork.LRPs) > 0 || len(cell.unconfirmedWork.Tasks) > 0 {
+				unconfirmedCells = append(unconfirmedCells, cell)
+			}
+		}
+	}
+
+	results := make(chan reconciled, len(unconfirmedCells))
+	for _, cell := range unconfirmedCells {
+		cell := cell
+		s.workPool.Submit(func() {
+			missingWork, ok := cell.Reconcile()
+			results <- reconciled{cell, missingWork, ok}
+		})
+	}
+
+	missingWorks := []rep.Work{}
+	pending := map[*Cell]struct{}{}
+	for _, cell := range unconfirmedCells {
+		pending[cell] = struct{}{}
+	}
+	for range unconfirmedCells {
+		select {
+		case result := <-results:
+			delete(pending, result.cell)
+			if result.ok {
+				missingWorks = append(missingWorks, result.missingWork)
+			} else {
+				s.logUnknownWork(result.cell)
+			}
+		case <-ctx.Done():
+			s.logger.Error("failed-to-reconcile-every-cell", ctx.Err(), lager.Data{"cell-count": len(pending)})
+			for cell := range pending {
+				s.logUnknownWork(cell)
+			}
+			return missingWorks
+		}
+	}
+	return missingWorks
+}
+
+// logUnknownWork logs the work the cell may or may not have started.
+func (s *Scheduler) logUnknownWork(cell *Cell) {
+	for i := range cell.unconfirmedWork.LRPs {
+		s.logger.Info("lrp-unknown-after-failed-commit", lager.Data{"lrp-guid": cell.unconfirmedWork.LRPs[i].Identifier(), "cell-guid": cell.Guid})
+	}
+	for i := range cell.unconfirmedWork.Tasks {
+		s.logger.Info("task-unknown-after-failed-commit", lager.Data{"task-guid": cell.unconfirmedWork.Tasks[i].Identifier(), "cell-guid": cell.Guid})
+	}
+}
+
+// failMissingWork moves the work cells did not start from the successful to
+// the failed auctions.
+func (s *Scheduler) failMissingWork(p *placements, results *auctiontypes.AuctionResults, missingWorks []rep.Work) {
+	for _, work := range missingWorks {
+		for _, lrp := range work.LRPs {
+			identifier := lrp.Identifier()
+			if _, ok := p.successfulLRPs[identifier]; !ok {
+				continue
+			}
+			delete(p.successfulLRPs, identifier)
+
+			s.logger.Info("lrp-not-started", lager.Data{"lrp-guid": identifier, "cell-guid": work.CellID})
+			failed := *p.lrpStartAuctionLookup[identifier]
+			failed.PlacementError = auctiontypes.ErrorWorkNotStarted.Error()
+			results.FailedLRPs = append(results.FailedLRPs, failed)
+		}
+
+		for _, task := range work.Tasks {
+			identifier := task.Identifier()
+			if _, ok := p.successfulTasks[identifier]; !ok {
+				continue
+			}
+			delete(p.successfulTasks, identifier)
+
+			s.logger.Info("task-not-started", lager.Data{"task-guid": identifier, "cell-guid": work.CellID})
+			failed := *p.taskAuctionLookup[identifier]
+			failed.PlacementError = auctiontypes.ErrorWorkNotStarted.Error()
+			results.FailedTasks = append(results.FailedTasks, failed)
+		}
+	}
+}
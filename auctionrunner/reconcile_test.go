@@ -0,0 +1,121 @@
+package auctionrunner_test
+
+import (
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/workpool"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/rep/repfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Commit reconciliation", func() {
+	var (
+		client      *repfakes.FakeSimClient
+		zones       map[string]auctionrunner.Zone
+		clock       *fakeclock.FakeClock
+		workPool    *workpool.WorkPool
+		logger      *lagertest.TestLogger
+		options     []auctionrunner.SchedulerOption
+		lrpAuction  auctiontypes.LRPAuction
+		taskAuction auctiontypes.TaskAuction
+		results     auctiontypes.AuctionResults
+	)
+
+	BeforeEach(func() {
+		clock = fakeclock.NewFakeClock(time.Now())
+
+		var err error
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		logger = lagertest.NewTestLogger("reconciliation")
+		options = []auctionrunner.SchedulerOption{auctionrunner.WithCommitReconciliation()}
+
+		client = &repfakes.FakeSimClient{}
+		zones = map[string]auctionrunner.Zone{
+			"A-zone": auctionrunner.Zone{
+				auctionrunner.NewCell(logger, "A-cell", client, BuildCellState("A-cell", "A-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+			},
+		}
+
+		lrpAuction = BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{})
+		taskAuction = BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now())
+
+		client.PerformReturns(rep.Work{}, errors.New("timeout"))
+		client.StateReturns(rep.CellState{LRPs: []rep.LRP{lrpAuction.LRP}}, nil)
+	})
+
+	JustBeforeEach(func() {
+		scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0, options...)
+		results = scheduler.Schedule(auctiontypes.AuctionRequest{
+			LRPs:  []auctiontypes.LRPAuction{lrpAuction},
+			Tasks: []auctiontypes.TaskAuction{taskAuction},
+		})
+	})
+
+	AfterEach(func() {
+		workPool.Stop()
+	})
+
+	It("reports work the cell started as successful", func() {
+		Expect(results.SuccessfulLRPs).To(HaveLen(1))
+		Expect(results.SuccessfulLRPs[0].Winner).To(Equal("A-cell"))
+	})
+
+	It("fails work the cell did not start", func() {
+		Expect(results.SuccessfulTasks).To(BeEmpty())
+		Expect(results.FailedTasks).To(HaveLen(1))
+		Expect(results.FailedTasks[0].PlacementError).To(Equal(auctiontypes.ErrorWorkNotStarted.Error()))
+	})
+
+	Context("when the cell state cannot be fetched", func() {
+		BeforeEach(func() {
+			client.StateReturns(rep.CellState{}, errors.New("timeout"))
+		})
+
+		It("reports the work as successful", func() {
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulTasks).To(HaveLen(1))
+			Expect(results.FailedLRPs).To(BeEmpty())
+			Expect(results.FailedTasks).To(BeEmpty())
+		})
+
+		It("logs the work as unknown", func() {
+			Expect(logger).To(gbytes.Say("lrp-unknown-after-failed-commit"))
+			Expect(logger).To(gbytes.Say("task-unknown-after-failed-commit"))
+		})
+	})
+
+	Context("when the commit succeeds", func() {
+		BeforeEach(func() {
+			client.PerformReturns(rep.Work{}, nil)
+		})
+
+		It("does not fetch the cell state", func() {
+			Expect(results.SuccessfulTasks).To(HaveLen(1))
+			Expect(client.StateCallCount()).To(BeZero())
+		})
+	})
+
+	Context("without reconciliation", func() {
+		BeforeEach(func() {
+			options = nil
+		})
+
+		It("reports the work as successful", func() {
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulTasks).To(HaveLen(1))
+			Expect(client.StateCallCount()).To(BeZero())
+		})
+	})
+})
@@ -0,0 +1,78 @@
+package auctionrunner
+
+import (
+	"context"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/rep"
+)
+
+// WithTwoPhaseCommit commits work in two phases on cells whose client is an
+// auctiontypes.ReservingClient: every cell is first asked to hold its work for
+// ttl, and the held work is then confirmed. Work a cell rejects while
+// reserving fails as if it had been rejected on commit. When reserving takes
+// longer than ttl the holds may have lapsed, so they are released and their
+// work fails instead of being confirmed. When the auction ends before the work
+// is confirmed, nothing is confirmed or committed: the holds are released and
+// every cell's work fails. Other cells are committed as usual.
+func WithTwoPhaseCommit(ttl time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.reservationTTL = ttl
+	}
+}
+
+func (s *Scheduler) reserveAndConfirmCells(ctx context.Context) []rep.Work {
+	// closed once the cell is done reserving, since eachCell stops waiting for
+	// slow cells when ctx is done
+	reserved := map[*Cell]chan struct{}{}
+	for _, cells := range s.zones {
+		for _, cell := range cells {
+			reserved[cell] = make(chan struct{})
+		}
+	}
+
+	start := s.clock.Now()
+	rejectedWorks := s.eachCell(ctx, func(cell *Cell) rep.Work {
+		defer close(reserved[cell])
+		return cell.Reserve(s.reservationTTL)
+	})
+	if ctx.Err() != nil {
+		return s.abandonReservations(reserved)
+	}
+
+	confirm := (*Cell).Confirm
+	if elapsed := s.clock.Since(start); elapsed >= s.reservationTTL {
+		s.logger.Info("releasing-expired-reservations", lager.Data{"duration": elapsed.String()})
+		confirm = func(cell *Cell) rep.Work {
+			if cell.reserving {
+				return cell.Release()
+			}
+			return cell.Commit()
+		}
+	}
+
+	return append(rejectedWorks, s.eachCell(ctx, confirm)...)
+}
+
+// abandonReservations releases the work every cell holds once it is done
+// reserving, and returns the work to commit of every cell as rejected.
+func (s *Scheduler) abandonReservations(reserved map[*Cell]chan struct{}) []rep.Work {
+	s.logger.Info("releasing-reservations-of-ended-auction", lager.Data{"cell-count": len(reserved)})
+
+	abandonedWorks := []rep.Work{}
+	for cell, done := range reserved {
+		cell, done := cell, done
+		go func() {
+			<-done
+			if cell.reserving {
+				cell.Release()
+			}
+		}()
+
+		if len(cell.workToCommit.LRPs) > 0 || len(cell.workToCommit.Tasks) > 0 {
+			abandonedWorks = append(abandonedWorks, rep.Work{CellID: cell.Guid, LRPs: cell.workToCommit.LRPs, Tasks: cell.workToCommit.Tasks})
+		}
+	}
+	return abandonedWorks
+}
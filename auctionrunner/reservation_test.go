@@ -0,0 +1,188 @@
+package auctionrunner_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/workpool"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/rep/repfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// reservingClient holds work on behalf of a fake cell.
+type reservingClient struct {
+	*repfakes.FakeSimClient
+
+	lock       sync.Mutex
+	reserved   []rep.Work
+	ttls       []time.Duration
+	rejectAll  bool
+	confirmed  []string
+	confirmErr error
+	released   []string
+	onReserve  func()
+}
+
+func (c *reservingClient) Reserve(_ lager.Logger, work rep.Work, ttl time.Duration) (string, rep.Work, error) {
+	c.reserved = append(c.reserved, work)
+	c.ttls = append(c.ttls, ttl)
+	if c.onReserve != nil {
+		c.onReserve()
+	}
+	if c.rejectAll {
+		return "reservation-1", work, nil
+	}
+	return "reservation-1", rep.Work{}, nil
+}
+
+func (c *reservingClient) Confirm(_ lager.Logger, reservationID string) (rep.Work, error) {
+	c.confirmed = append(c.confirmed, reservationID)
+	return rep.Work{}, c.confirmErr
+}
+
+func (c *reservingClient) Release(_ lager.Logger, reservationID string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.released = append(c.released, reservationID)
+	return nil
+}
+
+func (c *reservingClient) Released() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string{}, c.released...)
+}
+
+var _ = Describe("Two-phase commit", func() {
+	var (
+		client      *reservingClient
+		plainClient *repfakes.FakeSimClient
+		zones       map[string]auctionrunner.Zone
+		clock       *fakeclock.FakeClock
+		workPool    *workpool.WorkPool
+		logger      *lagertest.TestLogger
+		lrpAuctions []auctiontypes.LRPAuction
+		ctx         context.Context
+		results     auctiontypes.AuctionResults
+	)
+
+	BeforeEach(func() {
+		clock = fakeclock.NewFakeClock(time.Now())
+
+		var err error
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx = context.Background()
+		logger = lagertest.NewTestLogger("two-phase-commit")
+		client = &reservingClient{FakeSimClient: &repfakes.FakeSimClient{}}
+		plainClient = &repfakes.FakeSimClient{}
+
+		zones = map[string]auctionrunner.Zone{
+			"A-zone": auctionrunner.Zone{
+				auctionrunner.NewCell(logger, "A-cell", client, BuildCellState("A-cell", "A-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+			},
+			"B-zone": auctionrunner.Zone{
+				auctionrunner.NewCell(logger, "B-cell", plainClient, BuildCellState("B-cell", "B-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+			},
+		}
+
+		lrpAuctions = []auctiontypes.LRPAuction{
+			BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{}),
+			BuildLRPAuction("pg-1", "domain", 1, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{}),
+		}
+	})
+
+	JustBeforeEach(func() {
+		scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0, auctionrunner.WithTwoPhaseCommit(time.Minute))
+		results = scheduler.ScheduleContext(ctx, auctiontypes.AuctionRequest{LRPs: lrpAuctions})
+	})
+
+	AfterEach(func() {
+		workPool.Stop()
+	})
+
+	It("reserves the work and then confirms it", func() {
+		Expect(results.SuccessfulLRPs).To(HaveLen(2))
+
+		Expect(client.reserved).To(HaveLen(1))
+		Expect(client.reserved[0].LRPs).To(HaveLen(1))
+		Expect(client.ttls).To(ConsistOf(time.Minute))
+		Expect(client.confirmed).To(ConsistOf("reservation-1"))
+		Expect(client.PerformCallCount()).To(BeZero())
+	})
+
+	It("commits cells that cannot reserve work", func() {
+		Expect(plainClient.PerformCallCount()).To(Equal(1))
+		_, work := plainClient.PerformArgsForCall(0)
+		Expect(work.LRPs).To(HaveLen(1))
+	})
+
+	Context("when the cell rejects work while reserving", func() {
+		BeforeEach(func() {
+			client.rejectAll = true
+		})
+
+		It("fails the rejected work", func() {
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Winner).To(Equal("B-cell"))
+			Expect(results.FailedLRPs).To(HaveLen(1))
+		})
+	})
+
+	Context("when the reservation expires before it is confirmed", func() {
+		BeforeEach(func() {
+			client.confirmErr = auctiontypes.ErrorReservationExpired
+		})
+
+		It("fails the held work", func() {
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Winner).To(Equal("B-cell"))
+			Expect(results.FailedLRPs).To(HaveLen(1))
+		})
+	})
+
+	Context("when reserving takes longer than the ttl", func() {
+		BeforeEach(func() {
+			client.onReserve = func() { clock.Increment(time.Minute) }
+		})
+
+		It("releases the reservations instead of confirming them", func() {
+			Expect(client.confirmed).To(BeEmpty())
+			Expect(client.Released()).To(ConsistOf("reservation-1"))
+			Expect(results.FailedLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Winner).To(Equal("B-cell"))
+		})
+	})
+
+	Context("when the auction ends after reserving", func() {
+		BeforeEach(func() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithCancel(context.Background())
+			client.onReserve = cancel
+		})
+
+		It("releases the reservations and fails the work of every cell", func() {
+			Eventually(client.Released).Should(ConsistOf("reservation-1"))
+			Expect(client.confirmed).To(BeEmpty())
+			Expect(plainClient.PerformCallCount()).To(BeZero())
+
+			Expect(results.SuccessfulLRPs).To(BeEmpty())
+			Expect(results.FailedLRPs).To(HaveLen(2))
+			for _, failed := range results.FailedLRPs {
+				Expect(failed.PlacementError).To(Equal(context.Canceled.Error()))
+			}
+		})
+	})
+})
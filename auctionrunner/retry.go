@@ -0,0 +1,252 @@
+package auctionrunner
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/lager"
+)
+
+// A RetryPolicy auctions work that failed with a retryable placement error
+// again, after a backoff that doubles with every attempt from InitialBackoff
+// up to MaxBackoff. Work is reported as failed once it has been auctioned
+// MaxAttempts times or fails with an error Retryable rejects.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration                    // 0 does not cap the backoff
+	Retryable      func(placementError string) bool // nil uses RetryablePlacementError
+}
+
+// WithRetryPolicy retries failed auctions under policy instead of reporting
+// every failure to the delegate.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(a *auctionRunner) {
+		if policy.Retryable == nil {
+			policy.Retryable = RetryablePlacementError
+		}
+		a.retryPolicy = &policy
+	}
+}
+
+// RetryablePlacementError retries every placement error except those no cell
+// satisfies until cells are reconfigured: a missing rootfs, volume driver or
+// placement tag.
+func RetryablePlacementError(placementError string) bool {
+	switch {
+	case placementError == auctiontypes.ErrorCellMismatch.Error():
+		return false
+	case placementError == auctiontypes.ErrorVolumeDriverMismatch.Error():
+		return false
+	case strings.HasPrefix(placementError, "found no compatible cell with") && strings.Contains(placementError, "placement tag"):
+		return false
+	}
+	return true
+}
+
+func (p *RetryPolicy) backoff(attempts int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return backoff
+}
+
+func (p *RetryPolicy) retries(attempts int, placementError string) bool {
+	return attempts < p.MaxAttempts && p.Retryable(placementError)
+}
+
+type retrier struct {
+	lock    *sync.Mutex
+	pending *sync.WaitGroup
+	stop    chan struct{}
+	stopped bool
+
+	// the auctions waiting out their backoff, by identifier
+	lrpAuctions  map[string]auctiontypes.LRPAuction
+	taskAuctions map[string]auctiontypes.TaskAuction
+}
+
+func newRetrier() *retrier {
+	return &retrier{
+		lock:         &sync.Mutex{},
+		pending:      &sync.WaitGroup{},
+		stop:         make(chan struct{}),
+		lrpAuctions:  map[string]auctiontypes.LRPAuction{},
+		taskAuctions: map[string]auctiontypes.TaskAuction{},
+	}
+}
+
+// retryFailed takes the failures the retry policy retries out of results and
+// queues them again once their backoff has passed. The caller holds the
+// retrier's lock.
+func (a *auctionRunner) retryFailed(logger lager.Logger, results *auctiontypes.AuctionResults) {
+	policy := a.retryPolicy
+
+	lrpRetries := map[time.Duration][]auctiontypes.LRPAuction{}
+	failedLRPs := results.FailedLRPs[:0]
+	for _, lrpAuction := range results.FailedLRPs {
+		if !policy.retries(lrpAuction.Attempts, lrpAuction.PlacementError) {
+			failedLRPs = append(failedLRPs, lrpAuction)
+			continue
+		}
+		backoff := policy.backoff(lrpAuction.Attempts)
+		lrpAuction.Winner = ""
+		lrpAuction.PlacementError = ""
+		lrpRetries[backoff] = append(lrpRetries[backoff], lrpAuction)
+	}
+	results.FailedLRPs = failedLRPs
+
+	startedGroups := startedTaskGroups(results.FailedTasks)
+	taskRetries := map[time.Duration][]auctiontypes.TaskAuction{}
+	failedTasks := results.FailedTasks[:0]
+	for _, taskAuction := range results.FailedTasks {
+		if _, ok := startedGroups[taskAuction.Group]; ok || !policy.retries(taskAuction.Attempts, taskAuction.PlacementError) {
+			failedTasks = append(failedTasks, taskAuction)
+			continue
+		}
+		backoff := policy.backoff(taskAuction.Attempts)
+		taskAuction.Winner = ""
+		taskAuction.PlacementError = ""
+		taskRetries[backoff] = append(taskRetries[backoff], taskAuction)
+	}
+	results.FailedTasks = failedTasks
+
+	for backoff, lrpAuctions := range lrpRetries {
+		a.retryAfter(logger, backoff, lrpAuctions, taskRetries[backoff])
+		delete(taskRetries, backoff)
+	}
+	for backoff, taskAuctions := range taskRetries {
+		a.retryAfter(logger, backoff, nil, taskAuctions)
+	}
+}
+
+// startedTaskGroups returns the task groups that failed with members already
+// started on a cell. Retrying any of their members would run those twice or
+// place the group in pieces.
+func startedTaskGroups(failedTasks []auctiontypes.TaskAuction) map[string]struct{} {
+	started := map[string]struct{}{}
+	for i := range failedTasks {
+		if failedTasks[i].Winner != "" && failedTasks[i].PlacementError == auctiontypes.ErrorTaskGroupIncomplete.Error() {
+			started[failedTasks[i].Group] = struct{}{}
+		}
+	}
+	return started
+}
+
+func (a *auctionRunner) retryAfter(logger lager.Logger, backoff time.Duration, lrpAuctions []auctiontypes.LRPAuction, taskAuctions []auctiontypes.TaskAuction) {
+	logger.Info("retrying-failed-auctions", lager.Data{
+		"lrp-start-auctions": len(lrpAuctions),
+		"task-auctions":      len(taskAuctions),
+		"backoff":            backoff.String(),
+	})
+
+	for _, lrpAuction := range lrpAuctions {
+		a.retrier.lrpAuctions[lrpAuction.Identifier()] = lrpAuction
+	}
+	for _, taskAuction := range taskAuctions {
+		a.retrier.taskAuctions[taskAuction.Identifier()] = taskAuction
+	}
+
+	if a.retrier.stopped {
+		a.requeue(lrpAuctions, taskAuctions)
+		return
+	}
+
+	a.retrier.pending.Add(1)
+	timer := a.clock.NewTimer(backoff)
+	go func() {
+		defer a.retrier.pending.Done()
+		select {
+		case <-timer.C():
+		case <-a.retrier.stop:
+			timer.Stop()
+		}
+
+		a.retrier.lock.Lock()
+		defer a.retrier.lock.Unlock()
+		a.requeue(lrpAuctions, taskAuctions)
+	}()
+}
+
+// cancelLRPs withdraws the retries of the given LRP instances and returns
+// them. The caller holds the retrier's lock.
+func (r *retrier) cancelLRPs(identifiers map[string]struct{}) []auctiontypes.LRPAuction {
+	cancelled := []auctiontypes.LRPAuction{}
+	for identifier := range identifiers {
+		if lrpAuction, ok := r.lrpAuctions[identifier]; ok {
+			cancelled = append(cancelled, lrpAuction)
+			delete(r.lrpAuctions, identifier)
+		}
+	}
+	return cancelled
+}
+
+// cancelTasks withdraws the retries of the given tasks and returns them. The
+// caller holds the retrier's lock.
+func (r *retrier) cancelTasks(identifiers map[string]struct{}) []auctiontypes.TaskAuction {
+	cancelled := []auctiontypes.TaskAuction{}
+	for identifier := range identifiers {
+		if taskAuction, ok := r.taskAuctions[identifier]; ok {
+			cancelled = append(cancelled, taskAuction)
+			delete(r.taskAuctions, identifier)
+		}
+	}
+	return cancelled
+}
+
+// stopRetrying queues every pending retry without waiting for its backoff.
+func (a *auctionRunner) stopRetrying() {
+	a.retrier.lock.Lock()
+	if !a.retrier.stopped {
+		a.retrier.stopped = true
+		close(a.retrier.stop)
+	}
+	a.retrier.lock.Unlock()
+
+	a.retrier.pending.Wait()
+}
+
+// requeue queues the retries that have not been cancelled in the meantime.
+// The caller holds the retrier's lock.
+func (a *auctionRunner) requeue(lrpAuctions []auctiontypes.LRPAuction, taskAuctions []auctiontypes.TaskAuction) {
+	retriedLRPs := make([]auctiontypes.LRPAuction, 0, len(lrpAuctions))
+	for _, lrpAuction := range lrpAuctions {
+		if _, ok := a.retrier.lrpAuctions[lrpAuction.Identifier()]; ok {
+			delete(a.retrier.lrpAuctions, lrpAuction.Identifier())
+			retriedLRPs = append(retriedLRPs, lrpAuction)
+		}
+	}
+	retriedTasks := make([]auctiontypes.TaskAuction, 0, len(taskAuctions))
+	for _, taskAuction := range taskAuctions {
+		if _, ok := a.retrier.taskAuctions[taskAuction.Identifier()]; ok {
+			delete(a.retrier.taskAuctions, taskAuction.Identifier())
+			retriedTasks = append(retriedTasks, taskAuction)
+		}
+	}
+	if len(retriedLRPs) == 0 && len(retriedTasks) == 0 {
+		return
+	}
+
+	a.batch.requeue(retriedLRPs, retriedTasks)
+	a.metricEmitter.QueueDepth(a.batch.Len())
+}
+
+// requeue queues auctions that were drained before again, regardless of the
+// capacity of the batch. They are still in the journal, as they were never
+// completed. They are queued ahead of the auctions that arrived since they
+// were drained, so that deduping keeps a resubmission over a stale retry
+// under DedupeKeepLatest.
+func (b *Batch) requeue(lrpAuctions []auctiontypes.LRPAuction, taskAuctions []auctiontypes.TaskAuction) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.lrpAuctions = append(append([]auctiontypes.LRPAuction{}, lrpAuctions...), b.lrpAuctions...)
+	b.taskAuctions = append(append([]auctiontypes.TaskAuction{}, taskAuctions...), b.taskAuctions...)
+	b.claimToHaveWork()
+}
@@ -0,0 +1,279 @@
+package auctionrunner_test
+
+import (
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/workpool"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/auction/auctiontypes/fakes"
+	"code.cloudfoundry.org/auctioneer"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/rep/repfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Retrying failed auctions", func() {
+	var (
+		clock    *fakeclock.FakeClock
+		client   *repfakes.FakeSimClient
+		delegate *handBackDelegate
+		workPool *workpool.WorkPool
+		runner   auctiontypes.AuctionRunner
+		signals  chan os.Signal
+		runErr   chan error
+	)
+
+	lrpStart := func(rootFS string) auctioneer.LRPStartRequest {
+		return auctioneer.NewLRPStartRequest("pg-1", "domain", []int{0}, rep.NewResource(50, 50, 50), rep.NewPlacementConstraint(rootFS, []string{}, []string{}))
+	}
+
+	BeforeEach(func() {
+		var err error
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		clock = fakeclock.NewFakeClock(time.Now())
+		client = &repfakes.FakeSimClient{}
+		client.StateReturns(BuildCellState("A-cell", "A-zone", 10, 10, 10, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0), nil)
+		delegate = &handBackDelegate{runnerDelegate: &runnerDelegate{client: client}}
+		signals = make(chan os.Signal)
+		runErr = make(chan error, 1)
+
+		runner = auctionrunner.New(
+			lagertest.NewTestLogger("retry"),
+			delegate,
+			&fakes.FakeAuctionMetricEmitterDelegate{},
+			clock,
+			workPool,
+			0.0,
+			0,
+			auctionrunner.WithRetryPolicy(auctionrunner.RetryPolicy{
+				MaxAttempts:    3,
+				InitialBackoff: 10 * time.Second,
+				MaxBackoff:     time.Minute,
+			}),
+			auctionrunner.WithShutdownMode(auctionrunner.ShutdownHandBack),
+		)
+
+		go func() {
+			runErr <- runner.Run(signals, make(chan struct{}))
+		}()
+	})
+
+	AfterEach(func() {
+		workPool.Stop()
+	})
+
+	stop := func() {
+		signals <- os.Interrupt
+		Eventually(runErr).Should(Receive(BeNil()))
+	}
+
+	Context("when an auction fails with a retryable error", func() {
+		BeforeEach(func() {
+			Expect(runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{lrpStart(linuxRootFSURL)})).To(Succeed())
+			Eventually(delegate.Completed).Should(HaveLen(1))
+		})
+
+		It("does not report the failure", func() {
+			Expect(delegate.Completed()[0].FailedLRPs).To(BeEmpty())
+			stop()
+		})
+
+		It("auctions the work again after a doubling backoff", func() {
+			clock.WaitForWatcherAndIncrement(10 * time.Second)
+			Eventually(delegate.Completed).Should(HaveLen(2))
+
+			clock.WaitForWatcherAndIncrement(10 * time.Second)
+			Consistently(delegate.Completed).Should(HaveLen(2))
+
+			clock.Increment(10 * time.Second)
+			Eventually(delegate.Completed).Should(HaveLen(3))
+			stop()
+		})
+
+		It("reports the failure after the last attempt", func() {
+			clock.WaitForWatcherAndIncrement(10 * time.Second)
+			Eventually(delegate.Completed).Should(HaveLen(2))
+			clock.WaitForWatcherAndIncrement(20 * time.Second)
+			Eventually(delegate.Completed).Should(HaveLen(3))
+
+			failed := delegate.Completed()[2].FailedLRPs
+			Expect(failed).To(HaveLen(1))
+			Expect(failed[0].Attempts).To(Equal(3))
+			Expect(failed[0].PlacementError).NotTo(BeEmpty())
+			stop()
+		})
+
+		It("does not auction work cancelled during its backoff", func() {
+			runner.CancelLRPAuctions([]models.ActualLRPKey{models.NewActualLRPKey("pg-1", 0, "domain")})
+
+			clock.WaitForWatcherAndIncrement(10 * time.Second)
+			Consistently(delegate.Completed).Should(HaveLen(1))
+			stop()
+			Expect(delegate.handedBackLRPs).To(BeEmpty())
+		})
+
+		It("hands back work waiting to be retried on shutdown", func() {
+			stop()
+			Expect(delegate.handedBackLRPs).To(HaveLen(1))
+			Expect(delegate.handedBackLRPs[0].Attempts).To(Equal(1))
+		})
+	})
+
+	Context("when a cell starts only part of a task group", func() {
+		BeforeEach(func() {
+			client.PerformStub = func(_ lager.Logger, work rep.Work) (rep.Work, error) {
+				failed := rep.Work{}
+				for _, task := range work.Tasks {
+					if task.TaskGuid == "gang-0" {
+						failed.Tasks = append(failed.Tasks, task)
+					}
+				}
+				return failed, nil
+			}
+
+			Expect(runner.ScheduleTaskGroupForAuction("gang", []auctioneer.TaskStartRequest{
+				BuildTaskStartRequest("gang-0", "domain", linuxRootFSURL, 1, 1, 1),
+				BuildTaskStartRequest("gang-1", "domain", linuxRootFSURL, 1, 1, 1),
+			})).To(Succeed())
+			Eventually(delegate.Completed).Should(HaveLen(1))
+		})
+
+		It("reports the whole group failed without retrying it", func() {
+			failed := delegate.Completed()[0].FailedTasks
+			Expect(failed).To(HaveLen(2))
+			for _, failedTask := range failed {
+				Expect(failedTask.PlacementError).To(Equal(auctiontypes.ErrorTaskGroupIncomplete.Error()))
+			}
+
+			clock.Increment(time.Minute)
+			Consistently(delegate.Completed).Should(HaveLen(1))
+			stop()
+			Expect(delegate.handedBackTasks).To(BeEmpty())
+		})
+	})
+
+	Context("when an auction fails with a terminal error", func() {
+		BeforeEach(func() {
+			Expect(runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{lrpStart("unsupported-rootfs")})).To(Succeed())
+			Eventually(delegate.Completed).Should(HaveLen(1))
+		})
+
+		It("reports the failure right away", func() {
+			failed := delegate.Completed()[0].FailedLRPs
+			Expect(failed).To(HaveLen(1))
+			Expect(failed[0].PlacementError).To(Equal(auctiontypes.ErrorCellMismatch.Error()))
+			stop()
+		})
+	})
+})
+
+var _ = Describe("Retrying auctions resubmitted during their backoff", func() {
+	var (
+		clock         *fakeclock.FakeClock
+		metricEmitter *fakes.FakeAuctionMetricEmitterDelegate
+		delegate      *runnerDelegate
+		workPool      *workpool.WorkPool
+		runner        auctiontypes.AuctionRunner
+		signals       chan os.Signal
+		runErr        chan error
+	)
+
+	lrpStart := func(memoryMB int32) auctioneer.LRPStartRequest {
+		return auctioneer.NewLRPStartRequest("pg-1", "domain", []int{0}, rep.NewResource(memoryMB, memoryMB, memoryMB), rep.NewPlacementConstraint(linuxRootFSURL, []string{}, []string{}))
+	}
+
+	lastQueueDepth := func() int {
+		count := metricEmitter.QueueDepthCallCount()
+		if count == 0 {
+			return 0
+		}
+		return metricEmitter.QueueDepthArgsForCall(count - 1)
+	}
+
+	BeforeEach(func() {
+		var err error
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		clock = fakeclock.NewFakeClock(time.Now())
+		metricEmitter = &fakes.FakeAuctionMetricEmitterDelegate{}
+		client := &repfakes.FakeSimClient{}
+		client.StateReturns(BuildCellState("A-cell", "A-zone", 10, 10, 10, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0), nil)
+		delegate = &runnerDelegate{client: client}
+		signals = make(chan os.Signal)
+		runErr = make(chan error, 1)
+
+		runner = auctionrunner.New(
+			lagertest.NewTestLogger("retry"),
+			delegate,
+			metricEmitter,
+			clock,
+			workPool,
+			0.0,
+			0,
+			auctionrunner.WithRetryPolicy(auctionrunner.RetryPolicy{
+				MaxAttempts:    3,
+				InitialBackoff: 10 * time.Second,
+			}),
+			auctionrunner.WithBatchOptions(
+				auctionrunner.WithBatchWindow(time.Minute, 0),
+				auctionrunner.WithDedupePolicy(auctionrunner.DedupeKeepLatest),
+			),
+		)
+
+		go func() {
+			runErr <- runner.Run(signals, make(chan struct{}))
+		}()
+
+		Expect(runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{lrpStart(50)})).To(Succeed())
+		Eventually(clock.WatcherCount).Should(Equal(1))
+		clock.Increment(time.Minute)
+		Eventually(delegate.Completed).Should(HaveLen(1))
+	})
+
+	AfterEach(func() {
+		signals <- os.Interrupt
+		Eventually(runErr).Should(Receive(BeNil()))
+		workPool.Stop()
+	})
+
+	It("auctions the resubmission instead of the stale retry", func() {
+		Expect(runner.ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest{lrpStart(5)})).To(Succeed())
+		Eventually(clock.WatcherCount).Should(Equal(2))
+
+		clock.Increment(10 * time.Second)
+		Eventually(lastQueueDepth).Should(Equal(2))
+
+		clock.Increment(time.Minute)
+		Eventually(delegate.Completed).Should(HaveLen(2))
+
+		results := delegate.Completed()[1]
+		Expect(results.FailedLRPs).To(BeEmpty())
+		Expect(results.SuccessfulLRPs).To(HaveLen(1))
+		Expect(results.SuccessfulLRPs[0].MemoryMB).To(BeEquivalentTo(5))
+	})
+})
+
+var _ = Describe("RetryablePlacementError", func() {
+	It("retries errors that cells may resolve", func() {
+		Expect(auctionrunner.RetryablePlacementError(auctiontypes.ErrorCellCommunication.Error())).To(BeTrue())
+		Expect(auctionrunner.RetryablePlacementError(rep.InsufficientResourcesError{}.Error())).To(BeTrue())
+	})
+
+	It("does not retry errors no cell resolves", func() {
+		Expect(auctionrunner.RetryablePlacementError(auctiontypes.ErrorCellMismatch.Error())).To(BeFalse())
+		Expect(auctionrunner.RetryablePlacementError(auctiontypes.ErrorVolumeDriverMismatch.Error())).To(BeFalse())
+		Expect(auctionrunner.RetryablePlacementError(auctiontypes.NewPlacementTagMismatchError([]string{"tag"}).Error())).To(BeFalse())
+	})
+})
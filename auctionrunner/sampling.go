@@ -0,0 +1,125 @@
+package auctionrunner
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// WithSampling scores only k randomly chosen eligible cells for every
+// auction instead of every cell. LRPs sample among the cells of the least
+// populated zones only, so zones stay balanced. When no sampled cell fits, the
+// auction falls back to scoring every cell. The rng makes the sample
+// reproducible; a nil rng is seeded from the clock. The rng must not be used
+// concurrently by anything else.
+func WithSampling(k int, rng *rand.Rand) SchedulerOption {
+	return func(s *Scheduler) {
+		if rng == nil {
+			rng = rand.New(rand.NewSource(s.clock.Now().UnixNano()))
+		}
+		s.sampleSize = k
+		s.sampleRNG = rng
+	}
+}
+
+// sampleLRPZones returns the zones tied for the fewest instances with only a
+// sample of their cells, or nil when there are no more cells than the sample
+// size.
+func (s *Scheduler) sampleLRPZones(sortedZones []lrpByZone) []lrpByZone {
+	if s.sampleSize <= 0 || len(sortedZones) == 0 {
+		return nil
+	}
+
+	tied := sortedZones[:1]
+	for len(tied) < len(sortedZones) && sameInstances(sortedZones[0], sortedZones[len(tied)]) {
+		tied = sortedZones[:len(tied)+1]
+	}
+
+	canonical := make([]lrpByZone, 0, len(tied))
+	for _, lrpZone := range tied {
+		lrpZone.zone = sortedByGuid(lrpZone.zone)
+		canonical = append(canonical, lrpZone)
+	}
+	sort.SliceStable(canonical, func(i, j int) bool {
+		return firstGuid(canonical[i].zone) < firstGuid(canonical[j].zone)
+	})
+
+	zones := make([]Zone, 0, len(canonical))
+	for _, lrpZone := range canonical {
+		zones = append(zones, lrpZone.zone)
+	}
+	sampled := s.drawCells(zones)
+	if sampled == nil {
+		return nil
+	}
+
+	for i := range canonical {
+		canonical[i].zone = sampled[i]
+	}
+	return canonical
+}
+
+// sampleZones returns the zones with only a sample of their cells, or nil
+// when there are no more cells than the sample size.
+func (s *Scheduler) sampleZones(zones []Zone) []Zone {
+	if s.sampleSize <= 0 {
+		return nil
+	}
+
+	canonical := make([]Zone, 0, len(zones))
+	for _, zone := range zones {
+		canonical = append(canonical, sortedByGuid(zone))
+	}
+	sort.SliceStable(canonical, func(i, j int) bool {
+		return firstGuid(canonical[i]) < firstGuid(canonical[j])
+	})
+	return s.drawCells(canonical)
+}
+
+// drawCells samples the cells of the zones, which must be ordered by guid so
+// that the same seed draws the same cells however the zones were built.
+func (s *Scheduler) drawCells(zones []Zone) []Zone {
+	total := 0
+	for _, zone := range zones {
+		total += len(zone)
+	}
+	if total <= s.sampleSize {
+		return nil
+	}
+
+	// Floyd's algorithm draws sampleSize distinct cells
+	chosen := make([]bool, total)
+	for j := total - s.sampleSize; j < total; j++ {
+		if i := s.sampleRNG.Intn(j + 1); !chosen[i] {
+			chosen[i] = true
+		} else {
+			chosen[j] = true
+		}
+	}
+
+	sampled := make([]Zone, 0, len(zones))
+	offset := 0
+	for _, zone := range zones {
+		cells := Zone{}
+		for i, cell := range zone {
+			if chosen[offset+i] {
+				cells = append(cells, cell)
+			}
+		}
+		offset += len(zone)
+		sampled = append(sampled, cells)
+	}
+	return sampled
+}
+
+func sortedByGuid(zone Zone) Zone {
+	sorted := append(Zone{}, zone...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Guid < sorted[j].Guid })
+	return sorted
+}
+
+func firstGuid(zone Zone) string {
+	if len(zone) == 0 {
+		return ""
+	}
+	return zone[0].Guid
+}
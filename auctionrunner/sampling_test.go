@@ -0,0 +1,168 @@
+package auctionrunner_test
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/workpool"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/rep/repfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// countingScorer counts the cells it scores.
+type countingScorer struct {
+	auctionrunner.Scorer
+	scored *int
+}
+
+func (s countingScorer) ScoreForLRP(cell *auctionrunner.Cell, lrp *rep.LRP, startingContainerWeight float64) (float64, error) {
+	*s.scored++
+	return s.Scorer.ScoreForLRP(cell, lrp, startingContainerWeight)
+}
+
+func (s countingScorer) ScoreForTask(cell *auctionrunner.Cell, task *rep.Task, startingContainerWeight float64) (float64, error) {
+	*s.scored++
+	return s.Scorer.ScoreForTask(cell, task, startingContainerWeight)
+}
+
+var _ = Describe("Sampling", func() {
+	var (
+		clock    *fakeclock.FakeClock
+		workPool *workpool.WorkPool
+		logger   *lagertest.TestLogger
+		memory   map[string]int32
+		scored   int
+	)
+
+	buildZones := func() map[string]auctionrunner.Zone {
+		zones := map[string]auctionrunner.Zone{}
+		for _, zone := range []string{"A", "B"} {
+			for i := 1; i <= 4; i++ {
+				cellID := fmt.Sprintf("%s-cell-%d", zone, i)
+				var lrps []rep.LRP
+				if cellID == "A-cell-1" {
+					lrps = []rep.LRP{*BuildLRP("pg-1", "domain", 0, "", 10, 10, 10, []string{})}
+				}
+				state := BuildCellState(cellID, zone+"-zone", memory[cellID], 100, 100, false, 0, linuxOnlyRootFSProviders, lrps, []string{}, []string{}, []string{}, 0)
+				zones[zone+"-zone"] = append(zones[zone+"-zone"], auctionrunner.NewCell(logger, cellID, &repfakes.FakeSimClient{}, state))
+			}
+			// the order cells are fetched in must not change the sample
+			cells := zones[zone+"-zone"]
+			rand.Shuffle(len(cells), func(i, j int) { cells[i], cells[j] = cells[j], cells[i] })
+		}
+		return zones
+	}
+
+	schedule := func(seed int64, k int, request auctiontypes.AuctionRequest) auctiontypes.AuctionResults {
+		scorer := countingScorer{Scorer: auctionrunner.NewDefaultScorer(), scored: &scored}
+		sampling := auctionrunner.WithSampling(k, rand.New(rand.NewSource(seed)))
+		scheduler := auctionrunner.NewScheduler(workPool, buildZones(), clock, logger, 0.0, 0, auctionrunner.WithScorer(scorer), sampling)
+		return scheduler.Schedule(request)
+	}
+
+	lrpRequest := func() auctiontypes.AuctionRequest {
+		lrpAuction := BuildLRPAuction("pg-1", "domain", 1, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{})
+		return auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{lrpAuction}}
+	}
+
+	BeforeEach(func() {
+		clock = fakeclock.NewFakeClock(time.Now())
+
+		var err error
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		logger = lagertest.NewTestLogger("sampling")
+		scored = 0
+		memory = map[string]int32{}
+		for _, zone := range []string{"A", "B"} {
+			for i := 1; i <= 4; i++ {
+				memory[fmt.Sprintf("%s-cell-%d", zone, i)] = 100
+			}
+		}
+	})
+
+	AfterEach(func() {
+		workPool.Stop()
+	})
+
+	It("scores only a sample of the cells of the least populated zone", func() {
+		results := schedule(1, 2, lrpRequest())
+
+		Expect(scored).To(Equal(2))
+		Expect(results.SuccessfulLRPs).To(HaveLen(1))
+		Expect(results.SuccessfulLRPs[0].Winner).To(HavePrefix("B-cell"))
+	})
+
+	It("picks the same cells for the same seed", func() {
+		first := schedule(7, 1, lrpRequest())
+		second := schedule(7, 1, lrpRequest())
+
+		Expect(first.SuccessfulLRPs).To(HaveLen(1))
+		Expect(second.SuccessfulLRPs).To(HaveLen(1))
+		Expect(second.SuccessfulLRPs[0].Winner).To(Equal(first.SuccessfulLRPs[0].Winner))
+	})
+
+	It("picks the same cells for the same seed across zones", func() {
+		request := func() auctiontypes.AuctionRequest {
+			request := auctiontypes.AuctionRequest{}
+			for i := 0; i < 3; i++ {
+				request.LRPs = append(request.LRPs, BuildLRPAuction("pg-2", "domain", i, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{}))
+				request.Tasks = append(request.Tasks, BuildTaskAuction(BuildTask(fmt.Sprintf("tg-%d", i), "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now()))
+			}
+			return request
+		}
+		winners := func(results auctiontypes.AuctionResults) map[string]string {
+			winners := map[string]string{}
+			for _, lrpAuction := range results.SuccessfulLRPs {
+				winners[lrpAuction.Identifier()] = lrpAuction.Winner
+			}
+			for _, taskAuction := range results.SuccessfulTasks {
+				winners[taskAuction.Identifier()] = taskAuction.Winner
+			}
+			return winners
+		}
+
+		for seed := int64(0); seed < 5; seed++ {
+			first := winners(schedule(seed, 3, request()))
+			Expect(first).To(HaveLen(6))
+			for run := 0; run < 10; run++ {
+				Expect(winners(schedule(seed, 3, request()))).To(Equal(first))
+			}
+		}
+	})
+
+	Context("when no sampled cell fits", func() {
+		BeforeEach(func() {
+			memory["B-cell-1"] = 5
+			memory["B-cell-2"] = 5
+			memory["B-cell-3"] = 5
+		})
+
+		It("falls back to scoring every cell", func() {
+			for seed := int64(0); seed < 10; seed++ {
+				results := schedule(seed, 1, lrpRequest())
+
+				Expect(results.SuccessfulLRPs).To(HaveLen(1))
+				Expect(results.SuccessfulLRPs[0].Winner).To(Equal("B-cell-4"))
+			}
+		})
+	})
+
+	It("samples the cells for tasks", func() {
+		task := BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now())
+		results := schedule(1, 3, auctiontypes.AuctionRequest{Tasks: []auctiontypes.TaskAuction{task}})
+
+		Expect(scored).To(Equal(3))
+		Expect(results.SuccessfulTasks).To(HaveLen(1))
+	})
+})
@@ -1,8 +1,10 @@
 package auctionrunner
 
 import (
+	"context"
+	"math/rand"
 	"sort"
-	"sync"
+	"time"
 
 	"code.cloudfoundry.org/auction/auctiontypes"
 	"code.cloudfoundry.org/rep"
@@ -40,6 +42,46 @@ func (z *Zone) filterCells(pc rep.PlacementConstraint) ([]*Cell, error) {
 	return cells, err
 }
 
+// filterInstanceCaps returns the cells with fewer than perCell instances of the
+// process guid. A perCell of zero means no limit.
+func (z *Zone) filterInstanceCaps(processGuid string, perCell int) []*Cell {
+	if perCell <= 0 {
+		return *z
+	}
+
+	var cells = make([]*Cell, 0, len(*z))
+	for _, cell := range *z {
+		if cell.instancesOf(processGuid) < perCell {
+			cells = append(cells, cell)
+		}
+	}
+	return cells
+}
+
+// filterAffinity returns the cells that satisfy the required affinity rules.
+func (z *Zone) filterAffinity(rules auctiontypes.AffinityRules) []*Cell {
+	if len(rules.Required) == 0 && len(rules.RequiredAnti) == 0 {
+		return *z
+	}
+
+	var cells = make([]*Cell, 0, len(*z))
+	for _, cell := range *z {
+		if cell.satisfiesAffinity(rules) {
+			cells = append(cells, cell)
+		}
+	}
+	return cells
+}
+
+// instancesOf returns the instances of the process guid in the zone.
+func (z *Zone) instancesOf(processGuid string) int {
+	instances := 0
+	for _, cell := range *z {
+		instances += cell.instancesOf(processGuid)
+	}
+	return instances
+}
+
 type Scheduler struct {
 	workPool                      *workpool.WorkPool
 	zones                         map[string]Zone
@@ -47,8 +89,22 @@ type Scheduler struct {
 	logger                        lager.Logger
 	startingContainerWeight       float64
 	startingContainerCountMaximum int // <=0 means no limit
+	scorer                        Scorer
+	preemption                    auctiontypes.Prioritizer // nil disables preemption
+	solverBudget                  time.Duration            // 0 disables the solver
+	solverNodeLimit               int                      // 0 uses defaultSolverNodeLimit
+	sampleSize                    int                      // <=0 scores every cell
+	sampleRNG                     *rand.Rand
+	commitRetries                 int           // 0 fails work rejected on commit
+	reconcile                     bool          // checks commits that failed with an error
+	reservationTTL                time.Duration // 0 commits in a single phase
+
+	preempted   []auctiontypes.PreemptedTask
+	explanation *explanation // only set while explaining
 }
 
+type SchedulerOption func(*Scheduler)
+
 func NewScheduler(
 	workPool *workpool.WorkPool,
 	zones map[string]Zone,
@@ -56,44 +112,157 @@ func NewScheduler(
 	logger lager.Logger,
 	startingContainerWeight float64,
 	startingContainerCountMaximum int,
+	options ...SchedulerOption,
 ) *Scheduler {
-	return &Scheduler{
+	s := &Scheduler{
 		workPool:                      workPool,
 		zones:                         zones,
 		clock:                         clock,
 		logger:                        logger,
 		startingContainerWeight:       startingContainerWeight,
 		startingContainerCountMaximum: startingContainerCountMaximum,
+		scorer:                        NewDefaultScorer(),
 	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
 }
 
 /*
 Schedule takes in a set of job requests (LRP start auctions and task starts) and
-assigns the work to available cells according to the diego scoring algorithm. The
+assigns the work to available cells according to the scheduler's Scorer. The
 scheduler is single-threaded.  It determines scheduling of jobs one at a time so
 that each calculation reflects available resources correctly.  It commits the
 work in batches at the end, for better network performance.  Schedule returns
 AuctionResults, indicating the success or failure of each requested job.
 */
 func (s *Scheduler) Schedule(auctionRequest auctiontypes.AuctionRequest) auctiontypes.AuctionResults {
-	results := auctiontypes.AuctionResults{}
+	return s.ScheduleContext(context.Background(), auctionRequest)
+}
 
+// ScheduleContext is Schedule bounded by ctx. When ctx is done before the
+// work is committed, nothing is committed and the placed work fails with the
+// context's error, as does work rejected or abandoned while committing when
+// ctx is done. Cells that are still committing when ctx is done are not waited
+// for, and their work is reported as placed.
+func (s *Scheduler) ScheduleContext(ctx context.Context, auctionRequest auctiontypes.AuctionRequest) auctiontypes.AuctionResults {
 	if len(s.zones) == 0 {
-		results.FailedLRPs = auctionRequest.LRPs
-		for i, _ := range results.FailedLRPs {
-			results.FailedLRPs[i].PlacementError = auctiontypes.ErrorCellCommunication.Error()
+		return s.markResults(failAll(auctionRequest))
+	}
+
+	p := s.place(auctionRequest)
+	if err := ctx.Err(); err != nil {
+		s.logger.Error("auction-cancelled-before-commit", err)
+		return s.markResults(failPlaced(p, err))
+	}
+
+	failedWorks := s.commitCells(ctx)
+	for retries := 0; retries < s.commitRetries && hasRejectedWork(failedWorks) && ctx.Err() == nil; retries++ {
+		failedWorks = s.replaceRejectedWork(ctx, &p, failedWorks)
+	}
+
+	results := p.results
+	commitErr := ctx.Err()
+	for _, failedWork := range failedWorks {
+		for _, failedStart := range failedWork.LRPs {
+			identifier := failedStart.Identifier()
+			delete(p.successfulLRPs, identifier)
+
+			s.logger.Info("lrp-failed-to-be-placed", lager.Data{"lrp-guid": failedStart.Identifier()})
+			failed := *p.lrpStartAuctionLookup[identifier]
+			if commitErr != nil && failed.PlacementError == "" {
+				failed.PlacementError = commitErr.Error()
+			}
+			results.FailedLRPs = append(results.FailedLRPs, failed)
 		}
-		results.FailedTasks = auctionRequest.Tasks
-		for i, _ := range results.FailedTasks {
-			results.FailedTasks[i].PlacementError = auctiontypes.ErrorCellCommunication.Error()
+
+		for _, failedTask := range failedWork.Tasks {
+			identifier := failedTask.Identifier()
+			delete(p.successfulTasks, identifier)
+
+			s.logger.Info("task-failed-to-be-placed", lager.Data{"task-guid": failedTask.Identifier()})
+			failed := *p.taskAuctionLookup[identifier]
+			if commitErr != nil && failed.PlacementError == "" {
+				failed.PlacementError = commitErr.Error()
+			}
+			results.FailedTasks = append(results.FailedTasks, failed)
+		}
+	}
+
+	if s.reconcile && ctx.Err() == nil {
+		s.failMissingWork(&p, &results, s.reconcileCells(ctx))
+	}
+	s.failIncompleteGroups(&p, &results)
+
+	for _, successfulStart := range p.successfulLRPs {
+		s.logger.Info("lrp-added-to-cell", lager.Data{"lrp-guid": successfulStart.Identifier(), "cell-guid": successfulStart.Winner})
+		results.SuccessfulLRPs = append(results.SuccessfulLRPs, *successfulStart)
+	}
+	for _, successfulTask := range p.successfulTasks {
+		s.logger.Info("task-added-to-cell", lager.Data{"task-guid": successfulTask.Identifier(), "cell-guid": successfulTask.Winner})
+		results.SuccessfulTasks = append(results.SuccessfulTasks, *successfulTask)
+	}
+	return s.markResults(results)
+}
+
+func failAll(auctionRequest auctiontypes.AuctionRequest) auctiontypes.AuctionResults {
+	results := auctiontypes.AuctionResults{}
+
+	results.FailedLRPs = auctionRequest.LRPs
+	for i, _ := range results.FailedLRPs {
+		results.FailedLRPs[i].PlacementError = auctiontypes.ErrorCellCommunication.Error()
+	}
+	results.FailedTasks = auctionRequest.Tasks
+	for i, _ := range results.FailedTasks {
+		results.FailedTasks[i].PlacementError = auctiontypes.ErrorCellCommunication.Error()
+	}
+	return results
+}
+
+// failPlaced fails the placed work with err, without committing it.
+func failPlaced(p placements, err error) auctiontypes.AuctionResults {
+	results := p.results
+	results.PreemptedTasks = nil
+
+	for _, lrpAuction := range p.successfulLRPs {
+		failed := *lrpAuction
+		failed.Winner = ""
+		failed.PlacementError = err.Error()
+		results.FailedLRPs = append(results.FailedLRPs, failed)
+	}
+	for _, taskAuction := range p.successfulTasks {
+		failed := *taskAuction
+		failed.Winner = ""
+		failed.PlacementError = err.Error()
+		results.FailedTasks = append(results.FailedTasks, failed)
+	}
+	return results
+}
+
+// placements holds the outcome of reserving an auction request on the cells,
+// before any work has been committed.
+type placements struct {
+	results               auctiontypes.AuctionResults
+	successfulLRPs        map[string]*auctiontypes.LRPAuction
+	lrpStartAuctionLookup map[string]*auctiontypes.LRPAuction
+	successfulTasks       map[string]*auctiontypes.TaskAuction
+	taskAuctionLookup     map[string]*auctiontypes.TaskAuction
+}
+
+func (s *Scheduler) place(auctionRequest auctiontypes.AuctionRequest) placements {
+	if s.solverBudget > 0 {
+		if p, ok := s.solve(auctionRequest); ok {
+			return p
 		}
-		return s.markResults(results)
 	}
 
-	var successfulLRPs = map[string]*auctiontypes.LRPAuction{}
-	var lrpStartAuctionLookup = map[string]*auctiontypes.LRPAuction{}
-	var successfulTasks = map[string]*auctiontypes.TaskAuction{}
-	var taskAuctionLookup = map[string]*auctiontypes.TaskAuction{}
+	p := placements{
+		successfulLRPs:        map[string]*auctiontypes.LRPAuction{},
+		lrpStartAuctionLookup: map[string]*auctiontypes.LRPAuction{},
+		successfulTasks:       map[string]*auctiontypes.TaskAuction{},
+		taskAuctionLookup:     map[string]*auctiontypes.TaskAuction{},
+	}
 	var currentInflightContainerStarts int
 
 	for _, zone := range s.zones {
@@ -105,12 +274,10 @@ func (s *Scheduler) Schedule(auctionRequest auctiontypes.AuctionRequest) auction
 	sort.Sort(SortableLRPAuctions(auctionRequest.LRPs))
 	sort.Sort(SortableTaskAuctions(auctionRequest.Tasks))
 
-	lrpsBeforeTasks, lrpsAfterTasks := splitLRPS(auctionRequest.LRPs)
-
 	auctionLRP := func(lrpsToAuction []auctiontypes.LRPAuction) {
 		for i := range lrpsToAuction {
 			lrpAuction := &lrpsToAuction[i]
-			lrpStartAuctionLookup[lrpAuction.Identifier()] = lrpAuction
+			p.lrpStartAuctionLookup[lrpAuction.Identifier()] = lrpAuction
 
 			if s.exceededInflightContainerCreation(currentInflightContainerStarts) {
 				s.logger.Info(
@@ -121,80 +288,138 @@ func (s *Scheduler) Schedule(auctionRequest auctiontypes.AuctionRequest) auction
 					},
 				)
 				lrpAuction.PlacementError = auctiontypes.ErrorExceededInflightCreation.Error()
-				results.FailedLRPs = append(results.FailedLRPs, *lrpAuction)
+				s.explanation.forLRP(lrpAuction.Identifier()).rejectAll(s.zones, auctiontypes.FilterStageInflightLimit, auctiontypes.ErrorExceededInflightCreation)
+				p.results.FailedLRPs = append(p.results.FailedLRPs, *lrpAuction)
 				continue
 			}
 
 			successfulStart, err := s.scheduleLRPAuction(lrpAuction)
 			if err != nil {
 				lrpAuction.PlacementError = err.Error()
-				results.FailedLRPs = append(results.FailedLRPs, *lrpAuction)
+				p.results.FailedLRPs = append(p.results.FailedLRPs, *lrpAuction)
 			} else {
-				successfulLRPs[successfulStart.Identifier()] = successfulStart
+				p.successfulLRPs[successfulStart.Identifier()] = successfulStart
 				currentInflightContainerStarts++
 			}
 		}
 	}
 
-	auctionLRP(lrpsBeforeTasks)
-
+	taskGroups := map[string][]*auctiontypes.TaskAuction{}
 	for i := range auctionRequest.Tasks {
-		taskAuction := &auctionRequest.Tasks[i]
-		taskAuctionLookup[taskAuction.Identifier()] = taskAuction
+		if group := auctionRequest.Tasks[i].Group; group != "" {
+			taskGroups[group] = append(taskGroups[group], &auctionRequest.Tasks[i])
+		}
+	}
+
+	auctionTaskGroup := func(group string) {
+		members := taskGroups[group]
+		delete(taskGroups, group)
+
+		failGroup := func(err error) {
+			for _, taskAuction := range members {
+				taskAuction.PlacementError = err.Error()
+				p.results.FailedTasks = append(p.results.FailedTasks, *taskAuction)
+			}
+		}
+
+		for _, taskAuction := range members {
+			p.taskAuctionLookup[taskAuction.Identifier()] = taskAuction
+		}
 
-		if s.exceededInflightContainerCreation(currentInflightContainerStarts) {
+		if s.exceededInflightContainerCreation(currentInflightContainerStarts + len(members) - 1) {
 			s.logger.Info(
 				"exceeded-max-inflight-container-creation",
 				lager.Data{
 					"max-inflight": s.startingContainerCountMaximum,
-					"task-guid":    taskAuction.Identifier(),
+					"task-group":   group,
 				},
 			)
-			taskAuction.PlacementError = auctiontypes.ErrorExceededInflightCreation.Error()
-			results.FailedTasks = append(results.FailedTasks, *taskAuction)
-			continue
+			for _, taskAuction := range members {
+				s.explanation.forTask(taskAuction.Identifier()).rejectAll(s.zones, auctiontypes.FilterStageInflightLimit, auctiontypes.ErrorExceededInflightCreation)
+			}
+			failGroup(auctiontypes.ErrorExceededInflightCreation)
+			return
 		}
 
-		successfulTask, err := s.scheduleTaskAuction(taskAuction, s.startingContainerWeight)
-		if err != nil {
-			taskAuction.PlacementError = err.Error()
-			results.FailedTasks = append(results.FailedTasks, *taskAuction)
-		} else {
-			successfulTasks[successfulTask.Identifier()] = successfulTask
+		reserved := make([]*auctiontypes.TaskAuction, 0, len(members))
+		for _, taskAuction := range members {
+			successfulTask, err := s.scheduleTaskAuction(taskAuction, s.startingContainerWeight)
+			if err != nil {
+				s.logger.Info("task-group-failed-to-be-placed", lager.Data{"task-group": group, "task-guid": taskAuction.Identifier(), "error": err.Error()})
+				for _, reservedTask := range reserved {
+					findCell(s.zones, reservedTask.Winner).unreserveTask(&reservedTask.Task)
+				}
+				failGroup(auctiontypes.ErrorTaskGroupIncomplete)
+				return
+			}
+			reserved = append(reserved, successfulTask)
+		}
+
+		for _, successfulTask := range reserved {
+			p.successfulTasks[successfulTask.Identifier()] = successfulTask
 			currentInflightContainerStarts++
 		}
 	}
 
-	auctionLRP(lrpsAfterTasks)
+	auctionTasks := func(tasksToAuction []auctiontypes.TaskAuction) {
+		for i := range tasksToAuction {
+			taskAuction := &tasksToAuction[i]
+			if taskAuction.Group != "" {
+				// the whole group is auctioned with its first member
+				if _, ok := taskGroups[taskAuction.Group]; ok {
+					auctionTaskGroup(taskAuction.Group)
+				}
+				continue
+			}
+			p.taskAuctionLookup[taskAuction.Identifier()] = taskAuction
 
-	failedWorks := s.commitCells()
-	for _, failedWork := range failedWorks {
-		for _, failedStart := range failedWork.LRPs {
-			identifier := failedStart.Identifier()
-			delete(successfulLRPs, identifier)
+			if s.exceededInflightContainerCreation(currentInflightContainerStarts) {
+				s.logger.Info(
+					"exceeded-max-inflight-container-creation",
+					lager.Data{
+						"max-inflight": s.startingContainerCountMaximum,
+						"task-guid":    taskAuction.Identifier(),
+					},
+				)
+				taskAuction.PlacementError = auctiontypes.ErrorExceededInflightCreation.Error()
+				s.explanation.forTask(taskAuction.Identifier()).rejectAll(s.zones, auctiontypes.FilterStageInflightLimit, auctiontypes.ErrorExceededInflightCreation)
+				p.results.FailedTasks = append(p.results.FailedTasks, *taskAuction)
+				continue
+			}
 
-			s.logger.Info("lrp-failed-to-be-placed", lager.Data{"lrp-guid": failedStart.Identifier()})
-			results.FailedLRPs = append(results.FailedLRPs, *lrpStartAuctionLookup[identifier])
+			successfulTask, err := s.scheduleTaskAuction(taskAuction, s.startingContainerWeight)
+			if err != nil {
+				taskAuction.PlacementError = err.Error()
+				p.results.FailedTasks = append(p.results.FailedTasks, *taskAuction)
+			} else {
+				p.successfulTasks[successfulTask.Identifier()] = successfulTask
+				currentInflightContainerStarts++
+			}
 		}
+	}
 
-		for _, failedTask := range failedWork.Tasks {
-			identifier := failedTask.Identifier()
-			delete(successfulTasks, identifier)
+	// higher priority work is scheduled first, so that it can not be starved of
+	// resources or of in-flight starts by lower priority work in the same batch
+	lrps, tasks := auctionRequest.LRPs, auctionRequest.Tasks
+	for len(lrps) > 0 || len(tasks) > 0 {
+		priority := highestPriority(lrps, tasks)
 
-			s.logger.Info("task-failed-to-be-placed", lager.Data{"task-guid": failedTask.Identifier()})
-			results.FailedTasks = append(results.FailedTasks, *taskAuctionLookup[identifier])
-		}
-	}
+		var lrpsAtPriority []auctiontypes.LRPAuction
+		var tasksAtPriority []auctiontypes.TaskAuction
+		lrpsAtPriority, lrps = splitLRPsAtPriority(lrps, priority)
+		tasksAtPriority, tasks = splitTasksAtPriority(tasks, priority)
 
-	for _, successfulStart := range successfulLRPs {
-		s.logger.Info("lrp-added-to-cell", lager.Data{"lrp-guid": successfulStart.Identifier(), "cell-guid": successfulStart.Winner})
-		results.SuccessfulLRPs = append(results.SuccessfulLRPs, *successfulStart)
-	}
-	for _, successfulTask := range successfulTasks {
-		s.logger.Info("task-added-to-cell", lager.Data{"task-guid": successfulTask.Identifier(), "cell-guid": successfulTask.Winner})
-		results.SuccessfulTasks = append(results.SuccessfulTasks, *successfulTask)
+		lrpsBeforeTasks, lrpsAfterTasks := splitLRPS(lrpsAtPriority)
+
+		auctionLRP(lrpsBeforeTasks)
+		auctionTasks(tasksAtPriority)
+		auctionLRP(lrpsAfterTasks)
 	}
-	return s.markResults(results)
+
+	p.results.PreemptedTasks = s.preempted
+	s.preempted = nil
+
+	return p
 }
 
 func (s *Scheduler) markResults(results auctiontypes.AuctionResults) auctiontypes.AuctionResults {
@@ -230,56 +455,151 @@ func splitLRPS(lrps []auctiontypes.LRPAuction) ([]auctiontypes.LRPAuction, []auc
 	return lrps[:0], lrps[0:]
 }
 
-func (s *Scheduler) commitCells() []rep.Work {
-	wg := &sync.WaitGroup{}
-	for _, cells := range s.zones {
-		wg.Add(len(cells))
+// highestPriority returns the highest priority of the sorted lrps and tasks.
+func highestPriority(lrps []auctiontypes.LRPAuction, tasks []auctiontypes.TaskAuction) auctiontypes.Priority {
+	switch {
+	case len(lrps) == 0:
+		return tasks[0].Priority
+	case len(tasks) == 0:
+		return lrps[0].Priority
+	case lrps[0].Priority > tasks[0].Priority:
+		return lrps[0].Priority
+	default:
+		return tasks[0].Priority
 	}
+}
 
-	lock := &sync.Mutex{}
-	failedWorks := []rep.Work{}
+func splitLRPsAtPriority(lrps []auctiontypes.LRPAuction, priority auctiontypes.Priority) ([]auctiontypes.LRPAuction, []auctiontypes.LRPAuction) {
+	for idx, lrp := range lrps {
+		if lrp.Priority != priority {
+			return lrps[:idx], lrps[idx:]
+		}
+	}
+
+	return lrps, lrps[len(lrps):]
+}
+
+func splitTasksAtPriority(tasks []auctiontypes.TaskAuction, priority auctiontypes.Priority) ([]auctiontypes.TaskAuction, []auctiontypes.TaskAuction) {
+	for idx, task := range tasks {
+		if task.Priority != priority {
+			return tasks[:idx], tasks[idx:]
+		}
+	}
 
+	return tasks, tasks[len(tasks):]
+}
+
+func (s *Scheduler) commitCells(ctx context.Context) []rep.Work {
+	if s.reservationTTL > 0 {
+		return s.reserveAndConfirmCells(ctx)
+	}
+	return s.eachCell(ctx, (*Cell).Commit)
+}
+
+// eachCell calls f for every cell in parallel and returns the failed work. It
+// stops waiting for the remaining cells once ctx is done.
+func (s *Scheduler) eachCell(ctx context.Context, f func(*Cell) rep.Work) []rep.Work {
+	count := 0
+	for _, cells := range s.zones {
+		count += len(cells)
+	}
+
+	// buffered so that cells finishing after ctx is done do not block
+	results := make(chan rep.Work, count)
 	for _, cells := range s.zones {
 		for _, cell := range cells {
 			cell := cell
 			s.workPool.Submit(func() {
-				defer wg.Done()
-				failedWork := cell.Commit()
-
-				lock.Lock()
-				failedWorks = append(failedWorks, failedWork)
-				lock.Unlock()
+				failedWork := f(cell)
+				failedWork.CellID = cell.Guid
+				results <- failedWork
 			})
 		}
 	}
 
-	wg.Wait()
+	failedWorks := []rep.Work{}
+	for i := 0; i < count; i++ {
+		select {
+		case failedWork := <-results:
+			failedWorks = append(failedWorks, failedWork)
+		case <-ctx.Done():
+			s.logger.Error("dropped-slow-cells", ctx.Err(), lager.Data{"cell-count": count - i})
+			return failedWorks
+		}
+	}
 	return failedWorks
 }
 
 func (s *Scheduler) scheduleLRPAuction(lrpAuction *auctiontypes.LRPAuction) (*auctiontypes.LRPAuction, error) {
+	explainer := s.explanation.forLRP(lrpAuction.Identifier())
+	explainer.filter(s.zones, lrpAuction.PlacementConstraint)
+
+	sortedZones, skewed, err := lrpZones(s.zones, lrpAuction, explainer)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := map[string]struct{}{"disk": struct{}{}, "memory": struct{}{}, "containers": struct{}{}}
+	spread := newTopologySpread(s.zones, lrpAuction.ProcessGuid)
+
 	var winnerCell *Cell
-	winnerScore := 1e20
+	if sampledZones := s.sampleLRPZones(sortedZones); sampledZones != nil {
+		winnerCell = s.pickLRPCell(sampledZones, lrpAuction, spread, problems, explainer)
+	}
+	if winnerCell == nil {
+		winnerCell = s.pickLRPCell(sortedZones, lrpAuction, spread, problems, explainer)
+	}
+	explainer.rejectUnscored(auctiontypes.FilterStageZoneBalance, auctiontypes.ErrorZoneBalance)
 
-	zones := accumulateZonesByInstances(s.zones, lrpAuction.ProcessGuid)
+	if winnerCell == nil && s.preemption != nil && s.preemptForLRP(lrpAuction, sortedZones) {
+		s.logger.Info("lrp-waiting-for-preemption", lager.Data{"lrp-guid": lrpAuction.Identifier()})
+		return nil, auctiontypes.ErrorPreemptionPending
+	}
+
+	if winnerCell == nil && skewed {
+		s.logger.Error("lrp-auction-failed", auctiontypes.ErrorMaxSkewExceeded, lager.Data{"lrp-guid": lrpAuction.Identifier()})
+		return nil, auctiontypes.ErrorMaxSkewExceeded
+	}
 
-	filteredZones, err := filterZones(zones, lrpAuction)
+	if winnerCell == nil {
+		err := &rep.InsufficientResourcesError{Problems: problems}
+		s.logger.Error("lrp-auction-failed", err, lager.Data{"lrp-guid": lrpAuction.Identifier()})
+		return nil, err
+	}
+
+	err = winnerCell.ReserveLRP(&lrpAuction.LRP)
 	if err != nil {
+		s.logger.Error("lrp-failed-to-reserve-cell", err, lager.Data{"cell-guid": winnerCell.Guid, "lrp-guid": lrpAuction.Identifier()})
 		return nil, err
 	}
 
-	sortedZones := sortZonesByInstances(filteredZones)
-	problems := map[string]struct{}{"disk": struct{}{}, "memory": struct{}{}, "containers": struct{}{}}
+	winningAuction := lrpAuction.Copy()
+	winningAuction.Winner = winnerCell.Guid
+	return &winningAuction, nil
+}
+
+// pickLRPCell returns the best scoring cell of the least populated zones that
+// has room for the LRP, or nil.
+func (s *Scheduler) pickLRPCell(sortedZones []lrpByZone, lrpAuction *auctiontypes.LRPAuction, spread topologySpread, problems map[string]struct{}, explainer *cellExplainer) *Cell {
+	var winnerCell *Cell
+	winnerScore := 1e20
 
 	for zoneIndex, lrpByZone := range sortedZones {
 		for _, cell := range lrpByZone.zone {
-			score, err := cell.ScoreForLRP(&lrpAuction.LRP, s.startingContainerWeight)
+			score, err := s.scoreLRP(cell, lrpAuction)
 			if err != nil {
+				explainer.reject(cell, auctiontypes.FilterStageResources, err)
 				removeNonApplicableProblems(problems, err)
 				continue
 			}
+			explainer.score(cell, score)
 
-			if score < winnerScore {
+			// instances are spread across racks and hosts before cells are scored
+			c := 0
+			if winnerCell != nil {
+				c = spread.compare(cell, winnerCell)
+			}
+			if c < 0 || (c == 0 && score < winnerScore) {
 				winnerScore = score
 				winnerCell = cell
 			}
@@ -288,7 +608,7 @@ func (s *Scheduler) scheduleLRPAuction(lrpAuction *auctiontypes.LRPAuction) (*au
 		// if (not last zone) && (this zone has the same # of instances as the next sorted zone)
 		// acts as a tie breaker
 		if zoneIndex+1 < len(sortedZones) &&
-			lrpByZone.instances == sortedZones[zoneIndex+1].instances {
+			sameInstances(lrpByZone, sortedZones[zoneIndex+1]) {
 			continue
 		}
 
@@ -296,30 +616,24 @@ func (s *Scheduler) scheduleLRPAuction(lrpAuction *auctiontypes.LRPAuction) (*au
 			break
 		}
 	}
+	return winnerCell
+}
 
-	if winnerCell == nil {
-		err := &rep.InsufficientResourcesError{Problems: problems}
-		s.logger.Error("lrp-auction-failed", err, lager.Data{"lrp-guid": lrpAuction.Identifier()})
-		return nil, err
-	}
-
-	err = winnerCell.ReserveLRP(&lrpAuction.LRP)
+// scoreLRP scores the cell with the scorer, penalized for the preferred
+// affinity rules it breaks.
+func (s *Scheduler) scoreLRP(cell *Cell, lrpAuction *auctiontypes.LRPAuction) (float64, error) {
+	score, err := s.scorer.ScoreForLRP(cell, &lrpAuction.LRP, s.startingContainerWeight)
 	if err != nil {
-		s.logger.Error("lrp-failed-to-reserve-cell", err, lager.Data{"cell-guid": winnerCell.Guid, "lrp-guid": lrpAuction.Identifier()})
-		return nil, err
+		return 0, err
 	}
-
-	winningAuction := lrpAuction.Copy()
-	winningAuction.Winner = winnerCell.Guid
-	return &winningAuction, nil
+	return score + cell.affinityScore(lrpAuction.Affinity), nil
 }
 
 func (s *Scheduler) scheduleTaskAuction(taskAuction *auctiontypes.TaskAuction, startingContainerWeight float64) (*auctiontypes.TaskAuction, error) {
-	var winnerCell *Cell
-	winnerScore := 1e20
-
 	filteredZones := []Zone{}
 	var zoneError error
+	explainer := s.explanation.forTask(taskAuction.Identifier())
+	explainer.filter(s.zones, taskAuction.PlacementConstraint)
 
 	for _, zone := range s.zones {
 		cells, err := zone.filterCells(taskAuction.PlacementConstraint)
@@ -344,19 +658,12 @@ func (s *Scheduler) scheduleTaskAuction(taskAuction *auctiontypes.TaskAuction, s
 
 	problems := map[string]struct{}{"disk": struct{}{}, "memory": struct{}{}, "containers": struct{}{}}
 
-	for _, zone := range filteredZones {
-		for _, cell := range zone {
-			score, err := cell.ScoreForTask(&taskAuction.Task, startingContainerWeight)
-			if err != nil {
-				removeNonApplicableProblems(problems, err)
-				continue
-			}
-
-			if score < winnerScore {
-				winnerScore = score
-				winnerCell = cell
-			}
-		}
+	var winnerCell *Cell
+	if sampledZones := s.sampleZones(filteredZones); sampledZones != nil {
+		winnerCell = s.pickTaskCell(sampledZones, taskAuction, startingContainerWeight, problems, explainer)
+	}
+	if winnerCell == nil {
+		winnerCell = s.pickTaskCell(filteredZones, taskAuction, startingContainerWeight, problems, explainer)
 	}
 
 	if winnerCell == nil {
@@ -376,6 +683,30 @@ func (s *Scheduler) scheduleTaskAuction(taskAuction *auctiontypes.TaskAuction, s
 	return &winningAuction, nil
 }
 
+// pickTaskCell returns the best scoring cell that has room for the task, or nil.
+func (s *Scheduler) pickTaskCell(zones []Zone, taskAuction *auctiontypes.TaskAuction, startingContainerWeight float64, problems map[string]struct{}, explainer *cellExplainer) *Cell {
+	var winnerCell *Cell
+	winnerScore := 1e20
+
+	for _, zone := range zones {
+		for _, cell := range zone {
+			score, err := s.scorer.ScoreForTask(cell, &taskAuction.Task, startingContainerWeight)
+			if err != nil {
+				explainer.reject(cell, auctiontypes.FilterStageResources, err)
+				removeNonApplicableProblems(problems, err)
+				continue
+			}
+			explainer.score(cell, score)
+
+			if score < winnerScore {
+				winnerScore = score
+				winnerCell = cell
+			}
+		}
+	}
+	return winnerCell
+}
+
 // removeNonApplicableProblems modifies the 'problems' map to remove any problems that didn't show up on err.
 //
 // The list of problems to report should only consist of the problems that exist on every cell
@@ -391,6 +722,17 @@ func removeNonApplicableProblems(problems map[string]struct{}, err error) {
 	}
 }
 
+func findCell(zones map[string]Zone, guid string) *Cell {
+	for _, zone := range zones {
+		for _, cell := range zone {
+			if cell.Guid == guid {
+				return cell
+			}
+		}
+	}
+	return nil
+}
+
 func (s *Scheduler) exceededInflightContainerCreation(currentInflight int) bool {
 	return s.startingContainerCountMaximum > 0 && currentInflight >= s.startingContainerCountMaximum
 }
@@ -0,0 +1,156 @@
+package auctionrunner_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/workpool"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/rep/repfakes"
+)
+
+const (
+	benchmarkZones        = 10
+	benchmarkCells        = 10000
+	benchmarkLRPsPerCell  = 10
+	benchmarkProcessGuids = 1000
+	benchmarkAuctions     = 100
+
+	scoreBenchmarkCells       = 1000
+	scoreBenchmarkLRPsPerCell = 100
+)
+
+func benchmarkLRP(processGuid string, index int) rep.LRP {
+	return rep.NewLRP(
+		"",
+		models.NewActualLRPKey(processGuid, int32(index), "domain"),
+		rep.NewResource(10, 10, 10),
+		rep.NewPlacementConstraint(linuxRootFSURL, []string{}, []string{}),
+	)
+}
+
+// BenchmarkScheduleLRPs auctions LRPs across 10k cells running 100k LRPs,
+// building the cells for every auction as the runner does.
+func BenchmarkScheduleLRPs(b *testing.B) {
+	logger := lager.NewLogger("benchmark")
+	clock := fakeclock.NewFakeClock(time.Now())
+	client := &repfakes.FakeSimClient{}
+
+	workPool, err := workpool.NewWorkPool(50)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer workPool.Stop()
+
+	states := make([]rep.CellState, benchmarkCells)
+	for i := range states {
+		lrps := make([]rep.LRP, benchmarkLRPsPerCell)
+		for j := range lrps {
+			lrp := i*benchmarkLRPsPerCell + j
+			lrps[j] = benchmarkLRP(fmt.Sprintf("pg-%d", lrp%benchmarkProcessGuids), lrp/benchmarkProcessGuids)
+		}
+
+		total := rep.NewResources(10000, 10000, 1000)
+		available := total.Copy()
+		for j := range lrps {
+			available.Subtract(&lrps[j].Resource)
+		}
+
+		zone := fmt.Sprintf("zone-%d", i%benchmarkZones)
+		states[i] = rep.NewCellState(fmt.Sprintf("cell-%d", i), "", linuxOnlyRootFSProviders, available, total, lrps, nil, zone, 0, false, nil, nil, nil, 0)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		zones := map[string]auctionrunner.Zone{}
+		for i := range states {
+			zones[states[i].Zone] = append(zones[states[i].Zone], auctionrunner.NewCell(logger, states[i].CellID, client, states[i]))
+		}
+
+		lrpAuctions := make([]auctiontypes.LRPAuction, 0, benchmarkAuctions)
+		for i := 0; i < benchmarkAuctions; i++ {
+			lrp := benchmarkLRP(fmt.Sprintf("pg-%d", i), benchmarkCells*benchmarkLRPsPerCell/benchmarkProcessGuids)
+			lrpAuctions = append(lrpAuctions, auctiontypes.NewLRPAuction(lrp, clock.Now()))
+		}
+
+		scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0)
+		scheduler.Schedule(auctiontypes.AuctionRequest{LRPs: lrpAuctions})
+	}
+}
+
+// scanningScorer scores LRPs like the default scorer, but counts the instances
+// of the LRP on the cell by scanning the cell state instead of reading the
+// index.
+type scanningScorer struct {
+	auctionrunner.Scorer
+}
+
+func (scanningScorer) ScoreForLRP(cell *auctionrunner.Cell, lrp *rep.LRP, startingContainerWeight float64) (float64, error) {
+	state := cell.State()
+	err := state.ResourceMatch(&lrp.Resource)
+	if err != nil {
+		return 0, err
+	}
+
+	instances := 0
+	for i := range state.LRPs {
+		if state.LRPs[i].ProcessGuid == lrp.ProcessGuid {
+			instances++
+		}
+	}
+	return state.ComputeScore(&lrp.Resource, startingContainerWeight) + float64(auctionrunner.LocalityOffset*instances), nil
+}
+
+// BenchmarkScoreLRPs scores an LRP on 1k prebuilt cells running 100 LRPs
+// each, counting the instances on the cell from the index and by scanning the
+// cell state.
+func BenchmarkScoreLRPs(b *testing.B) {
+	logger := lager.NewLogger("benchmark")
+	client := &repfakes.FakeSimClient{}
+
+	cells := make([]*auctionrunner.Cell, scoreBenchmarkCells)
+	for i := range cells {
+		lrps := make([]rep.LRP, scoreBenchmarkLRPsPerCell)
+		for j := range lrps {
+			lrp := i*scoreBenchmarkLRPsPerCell + j
+			lrps[j] = benchmarkLRP(fmt.Sprintf("pg-%d", lrp%benchmarkProcessGuids), lrp/benchmarkProcessGuids)
+		}
+
+		total := rep.NewResources(10000, 10000, 1000)
+		available := total.Copy()
+		for j := range lrps {
+			available.Subtract(&lrps[j].Resource)
+		}
+
+		state := rep.NewCellState(fmt.Sprintf("cell-%d", i), "", linuxOnlyRootFSProviders, available, total, lrps, nil, "zone", 0, false, nil, nil, nil, 0)
+		cells[i] = auctionrunner.NewCell(logger, state.CellID, client, state)
+	}
+
+	lrp := benchmarkLRP("pg-0", scoreBenchmarkCells*scoreBenchmarkLRPsPerCell/benchmarkProcessGuids)
+
+	for _, bm := range []struct {
+		name   string
+		scorer auctionrunner.Scorer
+	}{
+		{"indexed", auctionrunner.NewDefaultScorer()},
+		{"scanned", scanningScorer{auctionrunner.NewDefaultScorer()}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				for _, cell := range cells {
+					_, err := bm.scorer.ScoreForLRP(cell, &lrp, 0.0)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -1,9 +1,13 @@
 package auctionrunner_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/lager/lagertest"
 	"code.cloudfoundry.org/workpool"
 
@@ -512,6 +516,303 @@ var _ = Describe("Scheduler", func() {
 			})
 		})
 
+		Context("when the LRP has affinity rules", func() {
+			var rules auctiontypes.AffinityRules
+
+			JustBeforeEach(func() {
+				startAuction = BuildLRPAuction("pg-5", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{})
+				startAuction.Affinity = rules
+				s := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0)
+				results = s.Schedule(auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{startAuction}})
+			})
+
+			Context("when it must run next to another process", func() {
+				BeforeEach(func() {
+					rules = auctiontypes.AffinityRules{Required: []string{"pg-1"}}
+				})
+
+				It("places it on a cell running that process", func() {
+					Expect(results.SuccessfulLRPs).To(HaveLen(1))
+					Expect(results.SuccessfulLRPs[0].Winner).To(Equal("A-cell"))
+				})
+			})
+
+			Context("when it must not run next to another process", func() {
+				BeforeEach(func() {
+					rules = auctiontypes.AffinityRules{RequiredAnti: []string{"pg-3"}}
+				})
+
+				It("places it on a cell not running that process", func() {
+					Expect(results.SuccessfulLRPs).To(HaveLen(1))
+					Expect(results.SuccessfulLRPs[0].Winner).To(Equal("A-cell"))
+				})
+			})
+
+			Context("when no cell satisfies the required rules", func() {
+				BeforeEach(func() {
+					rules = auctiontypes.AffinityRules{Required: []string{"pg-1"}, RequiredAnti: []string{"pg-2"}}
+				})
+
+				It("fails with an affinity error", func() {
+					Expect(results.FailedLRPs).To(HaveLen(1))
+					Expect(results.FailedLRPs[0].PlacementError).To(Equal(auctiontypes.ErrorAffinityMismatch.Error()))
+				})
+			})
+
+			Context("when it prefers to run next to another process", func() {
+				BeforeEach(func() {
+					rules = auctiontypes.AffinityRules{Preferred: []string{"pg-1"}}
+				})
+
+				It("favors a cell running that process", func() {
+					Expect(results.SuccessfulLRPs).To(HaveLen(1))
+					Expect(results.SuccessfulLRPs[0].Winner).To(Equal("A-cell"))
+				})
+			})
+
+			Context("when it prefers not to run next to another process", func() {
+				BeforeEach(func() {
+					rules = auctiontypes.AffinityRules{PreferredAnti: []string{"pg-3"}}
+				})
+
+				It("favors a cell not running that process", func() {
+					Expect(results.SuccessfulLRPs).To(HaveLen(1))
+					Expect(results.SuccessfulLRPs[0].Winner).To(Equal("A-cell"))
+				})
+			})
+
+			Context("when no cell satisfies the preferred rules", func() {
+				BeforeEach(func() {
+					rules = auctiontypes.AffinityRules{Preferred: []string{"pg-missing"}}
+				})
+
+				It("places it anyway", func() {
+					Expect(results.SuccessfulLRPs).To(HaveLen(1))
+					Expect(results.SuccessfulLRPs[0].Winner).To(Equal("B-cell"))
+				})
+			})
+
+			Context("when the other process is placed earlier in the same auction", func() {
+				It("places it next to the reservation", func() {
+					companion := BuildLRPAuction("pg-6", "domain", 0, linuxRootFSURL, 20, 10, 10, clock.Now(), nil, []string{})
+					sidecar := BuildLRPAuction("pg-7", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{})
+					sidecar.Affinity = auctiontypes.AffinityRules{Required: []string{"pg-6"}}
+
+					s := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0)
+					results = s.Schedule(auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{sidecar, companion}})
+
+					Expect(results.SuccessfulLRPs).To(HaveLen(2))
+					Expect(results.SuccessfulLRPs[0].Winner).To(Equal(results.SuccessfulLRPs[1].Winner))
+				})
+			})
+		})
+
+		Context("when the LRP has a spread constraint", func() {
+			var spread auctiontypes.SpreadConstraint
+
+			BeforeEach(func() {
+				zones["A-zone"] = auctionrunner.Zone{
+					auctionrunner.NewCell(logger, "A-cell", clients["A-cell"], BuildCellState("cellID", "A-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, []rep.LRP{
+						*BuildLRP("pg-8", "domain", 0, "", 10, 10, 10, []string{}),
+						*BuildLRP("pg-8", "domain", 1, "", 10, 10, 10, []string{}),
+					}, []string{}, []string{}, []string{}, 0)),
+				}
+				zones["B-zone"] = auctionrunner.Zone{
+					auctionrunner.NewCell(logger, "B-cell", clients["B-cell"], BuildCellState("cellID", "B-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, []rep.LRP{
+						*BuildLRP("pg-big", "domain", 0, "", 85, 10, 10, []string{}),
+					}, []string{}, []string{}, []string{}, 0)),
+				}
+			})
+
+			JustBeforeEach(func() {
+				startAuction = BuildLRPAuction("pg-8", "domain", 2, linuxRootFSURL, 20, 10, 10, clock.Now(), nil, []string{})
+				startAuction.Spread = spread
+				s := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0)
+				results = s.Schedule(auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{startAuction}})
+			})
+
+			Context("when the least populated zone is full and the constraint must hold", func() {
+				BeforeEach(func() {
+					spread = auctiontypes.SpreadConstraint{MaxSkew: 1, WhenUnsatisfiable: auctiontypes.SpreadFail}
+				})
+
+				It("fails with a skew error", func() {
+					Expect(results.SuccessfulLRPs).To(BeEmpty())
+					Expect(results.FailedLRPs).To(HaveLen(1))
+					Expect(results.FailedLRPs[0].PlacementError).To(Equal(auctiontypes.ErrorMaxSkewExceeded.Error()))
+				})
+			})
+
+			Context("when the least populated zone is full and the constraint is best effort", func() {
+				BeforeEach(func() {
+					spread = auctiontypes.SpreadConstraint{MaxSkew: 1, WhenUnsatisfiable: auctiontypes.SpreadBestEffort}
+				})
+
+				It("places it in a more populated zone", func() {
+					Expect(results.SuccessfulLRPs).To(HaveLen(1))
+					Expect(results.SuccessfulLRPs[0].Winner).To(Equal("A-cell"))
+				})
+			})
+
+			Context("when the skew stays within the maximum", func() {
+				BeforeEach(func() {
+					spread = auctiontypes.SpreadConstraint{MaxSkew: 3, WhenUnsatisfiable: auctiontypes.SpreadFail}
+				})
+
+				It("places it in any zone", func() {
+					Expect(results.SuccessfulLRPs).To(HaveLen(1))
+					Expect(results.SuccessfulLRPs[0].Winner).To(Equal("A-cell"))
+				})
+			})
+
+			Context("without a constraint", func() {
+				BeforeEach(func() {
+					spread = auctiontypes.SpreadConstraint{}
+				})
+
+				It("places it in a more populated zone", func() {
+					Expect(results.SuccessfulLRPs).To(HaveLen(1))
+					Expect(results.SuccessfulLRPs[0].Winner).To(Equal("A-cell"))
+				})
+			})
+		})
+
+		Context("when cells are located in a topology", func() {
+			var topology auctionrunner.MapTopologyResolver
+
+			cellWith := func(cellID, zone string, lrps ...rep.LRP) *auctionrunner.Cell {
+				return auctionrunner.NewCell(logger, cellID, clients["A-cell"], BuildCellState(cellID, zone, 100, 100, 100, false, 0, linuxOnlyRootFSProviders, lrps, []string{}, []string{}, []string{}, 0))
+			}
+
+			JustBeforeEach(func() {
+				auctionrunner.ResolveTopology(zones, topology)
+				startAuction = BuildLRPAuction("pg-9", "domain", 1, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{})
+				s := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0)
+				results = s.Schedule(auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{startAuction}})
+			})
+
+			Context("when an instance already runs in a rack", func() {
+				BeforeEach(func() {
+					zones = map[string]auctionrunner.Zone{
+						"A-zone": auctionrunner.Zone{
+							cellWith("r1-cell-1", "A-zone", *BuildLRP("pg-9", "domain", 0, "", 10, 10, 10, []string{})),
+							cellWith("r1-cell-2", "A-zone"),
+							cellWith("r2-cell-1", "A-zone", *BuildLRP("pg-other", "domain", 0, "", 50, 50, 50, []string{})),
+						},
+					}
+					topology = auctionrunner.MapTopologyResolver{
+						"r1-cell-1": {Rack: "r1", Host: "h1"},
+						"r1-cell-2": {Rack: "r1", Host: "h2"},
+						"r2-cell-1": {Rack: "r2", Host: "h3"},
+					}
+				})
+
+				It("places it in another rack", func() {
+					Expect(results.SuccessfulLRPs).To(HaveLen(1))
+					Expect(results.SuccessfulLRPs[0].Winner).To(Equal("r2-cell-1"))
+				})
+
+				Context("without a topology", func() {
+					BeforeEach(func() {
+						topology = auctionrunner.MapTopologyResolver{}
+					})
+
+					It("places it on the best scoring cell", func() {
+						Expect(results.SuccessfulLRPs).To(HaveLen(1))
+						Expect(results.SuccessfulLRPs[0].Winner).To(Equal("r1-cell-2"))
+					})
+				})
+			})
+
+			Context("when an instance already runs in a region", func() {
+				BeforeEach(func() {
+					zones = map[string]auctionrunner.Zone{
+						"A-zone": auctionrunner.Zone{
+							cellWith("A-cell", "A-zone", *BuildLRP("pg-9", "domain", 0, "", 10, 10, 10, []string{})),
+						},
+						"B-zone": auctionrunner.Zone{cellWith("B-cell", "B-zone")},
+						"C-zone": auctionrunner.Zone{
+							cellWith("C-cell", "C-zone", *BuildLRP("pg-other", "domain", 0, "", 50, 50, 50, []string{})),
+						},
+					}
+					topology = auctionrunner.MapTopologyResolver{
+						"A-cell": {Region: "east"},
+						"B-cell": {Region: "east"},
+						"C-cell": {Region: "west"},
+					}
+				})
+
+				It("places it in another region", func() {
+					Expect(results.SuccessfulLRPs).To(HaveLen(1))
+					Expect(results.SuccessfulLRPs[0].Winner).To(Equal("C-cell"))
+				})
+			})
+		})
+
+		Context("when the LRP has instance caps", func() {
+			var startAuctions []auctiontypes.LRPAuction
+
+			buildCappedAuctions := func(caps auctiontypes.InstanceCaps) []auctiontypes.LRPAuction {
+				auctions := []auctiontypes.LRPAuction{}
+				for index := 0; index < 3; index++ {
+					auction := BuildLRPAuction("pg-4", "domain", index, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{})
+					auction.Caps = caps
+					auctions = append(auctions, auction)
+				}
+				return auctions
+			}
+
+			JustBeforeEach(func() {
+				s := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0)
+				results = s.Schedule(auctiontypes.AuctionRequest{LRPs: startAuctions})
+			})
+
+			Context("with a cap per cell", func() {
+				BeforeEach(func() {
+					startAuctions = buildCappedAuctions(auctiontypes.InstanceCaps{PerCell: 1})
+				})
+
+				It("places at most that many instances on a cell", func() {
+					Expect(results.SuccessfulLRPs).To(HaveLen(2))
+					Expect(results.SuccessfulLRPs[0].Winner).NotTo(Equal(results.SuccessfulLRPs[1].Winner))
+				})
+
+				It("fails the rest with an instance cap error", func() {
+					Expect(results.FailedLRPs).To(HaveLen(1))
+					Expect(results.FailedLRPs[0].PlacementError).To(Equal(auctiontypes.ErrorInstanceCapReached.Error()))
+				})
+			})
+
+			Context("with a cap per zone", func() {
+				BeforeEach(func() {
+					clients["A2-cell"] = &repfakes.FakeSimClient{}
+					zones["A-zone"] = append(zones["A-zone"], auctionrunner.NewCell(
+						logger,
+						"A2-cell",
+						clients["A2-cell"],
+						BuildCellState("cellID", "A-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, []rep.LRP{}, []string{}, []string{}, []string{}, 0),
+					))
+					startAuctions = buildCappedAuctions(auctiontypes.InstanceCaps{PerZone: 1})
+				})
+
+				It("places at most that many instances in a zone", func() {
+					Expect(results.SuccessfulLRPs).To(HaveLen(2))
+					Expect(results.FailedLRPs).To(HaveLen(1))
+					Expect(results.FailedLRPs[0].PlacementError).To(Equal(auctiontypes.ErrorInstanceCapReached.Error()))
+				})
+			})
+
+			Context("without caps", func() {
+				BeforeEach(func() {
+					startAuctions = buildCappedAuctions(auctiontypes.InstanceCaps{})
+				})
+
+				It("places every instance", func() {
+					Expect(results.SuccessfulLRPs).To(HaveLen(3))
+				})
+			})
+		})
+
 		Context("when there is no room", func() {
 			var requestedDisk int32
 
@@ -896,6 +1197,78 @@ var _ = Describe("Scheduler", func() {
 			})
 		})
 
+		Context("when auctioning a task group", func() {
+			var groupAuctions []auctiontypes.TaskAuction
+
+			buildGroup := func(group string, size int, memoryMB int32) []auctiontypes.TaskAuction {
+				auctions := []auctiontypes.TaskAuction{}
+				for i := 0; i < size; i++ {
+					auction := BuildTaskAuction(BuildTask(fmt.Sprintf("%s-%d", group, i), "domain", linuxRootFSURL, memoryMB, 10, 10, []string{}, []string{}), clock.Now())
+					auction.Group = group
+					auctions = append(auctions, auction)
+				}
+				return auctions
+			}
+
+			Context("when every task in the group fits", func() {
+				BeforeEach(func() {
+					groupAuctions = buildGroup("gang", 3, 40)
+					s := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0)
+					results = s.Schedule(auctiontypes.AuctionRequest{Tasks: groupAuctions})
+				})
+
+				It("places the whole group", func() {
+					Expect(results.SuccessfulTasks).To(HaveLen(3))
+					Expect(results.FailedTasks).To(BeEmpty())
+					Expect(clients["A-cell"].PerformCallCount() + clients["B-cell"].PerformCallCount()).To(Equal(2))
+				})
+			})
+
+			Context("when only part of the group fits", func() {
+				BeforeEach(func() {
+					groupAuctions = buildGroup("gang", 5, 40)
+					soloAuction := BuildTaskAuction(BuildTask("tg-solo", "domain", linuxRootFSURL, 30, 10, 10, []string{}, []string{}), clock.Now())
+					s := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0)
+					results = s.Schedule(auctiontypes.AuctionRequest{Tasks: append(groupAuctions, soloAuction)})
+				})
+
+				It("fails every task in the group with a task group error", func() {
+					Expect(results.FailedTasks).To(HaveLen(5))
+					for _, failedTask := range results.FailedTasks {
+						Expect(failedTask.Group).To(Equal("gang"))
+						Expect(failedTask.PlacementError).To(Equal(auctiontypes.ErrorTaskGroupIncomplete.Error()))
+					}
+				})
+
+				It("gives the reserved resources back to the cells", func() {
+					Expect(results.SuccessfulTasks).To(HaveLen(1))
+					Expect(results.SuccessfulTasks[0].TaskGuid).To(Equal("tg-solo"))
+
+					Expect(clients["B-cell"].PerformCallCount()).To(Equal(1))
+					_, work := clients["B-cell"].PerformArgsForCall(0)
+					Expect(work.Tasks).To(HaveLen(1))
+					Expect(work.Tasks[0].TaskGuid).To(Equal("tg-solo"))
+					Expect(clients["A-cell"].PerformCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the group would exceed the maximum inflight container creations", func() {
+				BeforeEach(func() {
+					groupAuctions = buildGroup("gang", 3, 10)
+					s := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 2)
+					results = s.Schedule(auctiontypes.AuctionRequest{Tasks: groupAuctions})
+				})
+
+				It("fails the whole group", func() {
+					Expect(results.SuccessfulTasks).To(BeEmpty())
+					Expect(results.FailedTasks).To(HaveLen(3))
+					for _, failedTask := range results.FailedTasks {
+						Expect(failedTask.PlacementError).To(Equal(auctiontypes.ErrorExceededInflightCreation.Error()))
+					}
+				})
+			})
+		})
+
 		Context("when there is cell mismatch", func() {
 			BeforeEach(func() {
 				taskAuction = BuildTaskAuction(BuildTask("tg-1", "domain", "unsupported:rootfs", 100, 100, 10, []string{}, []string{}), clock.Now())
@@ -920,6 +1293,58 @@ var _ = Describe("Scheduler", func() {
 		})
 	})
 
+	Describe("scheduling with a context", func() {
+		var lrpAuction auctiontypes.LRPAuction
+
+		BeforeEach(func() {
+			clients["A-cell"] = &repfakes.FakeSimClient{}
+			zones["A-zone"] = auctionrunner.Zone{
+				auctionrunner.NewCell(logger, "A-cell", clients["A-cell"], BuildCellState("A-cell", "A-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+			}
+			lrpAuction = BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{})
+			scheduler = auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0)
+		})
+
+		Context("when the context is done before committing", func() {
+			It("commits nothing and fails the placed work", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				results = scheduler.ScheduleContext(ctx, auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{lrpAuction}})
+				Expect(clients["A-cell"].PerformCallCount()).To(BeZero())
+				Expect(results.SuccessfulLRPs).To(BeEmpty())
+				Expect(results.FailedLRPs).To(HaveLen(1))
+				Expect(results.FailedLRPs[0].PlacementError).To(Equal(context.Canceled.Error()))
+				Expect(results.FailedLRPs[0].Attempts).To(Equal(1))
+			})
+		})
+
+		Context("when a cell is slow to commit", func() {
+			var unblock chan struct{}
+
+			BeforeEach(func() {
+				unblock = make(chan struct{})
+				clients["A-cell"].PerformStub = func(lager.Logger, rep.Work) (rep.Work, error) {
+					<-unblock
+					return rep.Work{}, errors.New("too late")
+				}
+			})
+
+			AfterEach(func() {
+				close(unblock)
+			})
+
+			It("stops waiting for it once the context is done", func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+
+				results = scheduler.ScheduleContext(ctx, auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{lrpAuction}})
+				Expect(results.SuccessfulLRPs).To(HaveLen(1))
+				Expect(logger).To(gbytes.Say("dropped-slow-cells"))
+			})
+		})
+	})
+
 	Describe("a comprehensive scenario", func() {
 		BeforeEach(func() {
 			clients["A-cell"] = &repfakes.FakeSimClient{}
@@ -1025,6 +1450,8 @@ var _ = Describe("Scheduler", func() {
 			tg1, tg2               auctiontypes.TaskAuction
 			memory                 int32
 
+			startingContainerCountMaximum int
+
 			lrps  []auctiontypes.LRPAuction
 			tasks []auctiontypes.TaskAuction
 		)
@@ -1043,6 +1470,7 @@ var _ = Describe("Scheduler", func() {
 			tasks = []auctiontypes.TaskAuction{tg1, tg2}
 
 			memory = 100
+			startingContainerCountMaximum = 0
 		})
 
 		JustBeforeEach(func() {
@@ -1055,7 +1483,7 @@ var _ = Describe("Scheduler", func() {
 				Tasks: tasks,
 			}
 
-			scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0)
+			scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, startingContainerCountMaximum)
 			results = scheduler.Schedule(auctionRequest)
 		})
 
@@ -1128,6 +1556,44 @@ var _ = Describe("Scheduler", func() {
 			})
 		})
 
+		Context("when work has different priorities", func() {
+			BeforeEach(func() {
+				memory = 50
+				pg81.Priority = 1
+				tg1.Priority = 2
+				lrps = []auctiontypes.LRPAuction{pg70, pg71, pg81, pg82}
+				tasks = []auctiontypes.TaskAuction{tg1, tg2}
+			})
+
+			It("schedules higher priority work first", func() {
+				setLRPWinner("cell", &pg81)
+				setTaskWinner("cell", &tg1)
+
+				Expect(results.SuccessfulLRPs).To(ConsistOf(pg81))
+				Expect(results.SuccessfulTasks).To(ConsistOf(tg1))
+			})
+
+			Context("when the maximum inflight container creations is reached", func() {
+				BeforeEach(func() {
+					memory = 1000
+					startingContainerCountMaximum = 2
+				})
+
+				It("gives the in-flight starts to the higher priority work", func() {
+					setLRPWinner("cell", &pg81)
+					setTaskWinner("cell", &tg1)
+
+					Expect(results.SuccessfulLRPs).To(ConsistOf(pg81))
+					Expect(results.SuccessfulTasks).To(ConsistOf(tg1))
+					Expect(results.FailedLRPs).To(HaveLen(3))
+					Expect(results.FailedTasks).To(HaveLen(1))
+					for _, lrp := range results.FailedLRPs {
+						Expect(lrp.PlacementError).To(Equal(auctiontypes.ErrorExceededInflightCreation.Error()))
+					}
+				})
+			})
+		})
+
 		Context("when dealing with tasks", func() {
 			var tg3 auctiontypes.TaskAuction
 
@@ -0,0 +1,71 @@
+package auctionrunner
+
+import "code.cloudfoundry.org/rep"
+
+// A Scorer ranks cells for a piece of work. The scheduler places the work on
+// the cell with the lowest score. Scorers return an error when the work does
+// not fit on the cell.
+type Scorer interface {
+	ScoreForLRP(cell *Cell, lrp *rep.LRP, startingContainerWeight float64) (float64, error)
+	ScoreForTask(cell *Cell, task *rep.Task, startingContainerWeight float64) (float64, error)
+}
+
+// WithScorer scores the cells with scorer instead of NewDefaultScorer. A nil
+// scorer keeps the default.
+func WithScorer(scorer Scorer) SchedulerOption {
+	return func(s *Scheduler) {
+		if scorer != nil {
+			s.scorer = scorer
+		}
+	}
+}
+
+type defaultScorer struct{}
+
+// NewDefaultScorer returns the diego scoring algorithm: a resource score from
+// the cell state, plus LocalityOffset for every instance of the same work
+// already on the cell.
+func NewDefaultScorer() Scorer {
+	return defaultScorer{}
+}
+
+func (defaultScorer) ScoreForLRP(cell *Cell, lrp *rep.LRP, startingContainerWeight float64) (float64, error) {
+	return cell.ScoreForLRP(lrp, startingContainerWeight)
+}
+
+func (defaultScorer) ScoreForTask(cell *Cell, task *rep.Task, startingContainerWeight float64) (float64, error) {
+	return cell.ScoreForTask(task, startingContainerWeight)
+}
+
+type binPackScorer struct{}
+
+// NewBinPackScorer returns a scorer that prefers the most allocated cell the
+// work still fits on, keeping the remaining cells empty for large work or for
+// scale-down. Instances of the same LRP are still kept apart with
+// LocalityOffset. The starting container weight is ignored, since every
+// reservation would otherwise push the next piece of work onto another cell;
+// use the starting container maximum to limit concurrent starts instead.
+func NewBinPackScorer() Scorer {
+	return binPackScorer{}
+}
+
+func (binPackScorer) ScoreForLRP(cell *Cell, lrp *rep.LRP, _ float64) (float64, error) {
+	proxiedLRP := cell.proxiedResource(lrp)
+
+	err := cell.state.ResourceMatch(&proxiedLRP)
+	if err != nil {
+		return 0, err
+	}
+
+	localityScore := LocalityOffset * cell.instancesOf(lrp.ProcessGuid)
+	return float64(localityScore) - cell.state.ComputeScore(&proxiedLRP, 0), nil
+}
+
+func (binPackScorer) ScoreForTask(cell *Cell, task *rep.Task, _ float64) (float64, error) {
+	err := cell.state.ResourceMatch(&task.Resource)
+	if err != nil {
+		return 0, err
+	}
+
+	return -cell.state.ComputeScore(&task.Resource, 0), nil
+}
@@ -0,0 +1,253 @@
+package auctionrunner_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/workpool"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/rep/repfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fixedScorer struct {
+	scores map[string]float64
+}
+
+func (s fixedScorer) ScoreForLRP(cell *auctionrunner.Cell, lrp *rep.LRP, _ float64) (float64, error) {
+	state := cell.State()
+	if err := state.ResourceMatch(&lrp.Resource); err != nil {
+		return 0, err
+	}
+	return s.scores[cell.Guid], nil
+}
+
+func (s fixedScorer) ScoreForTask(cell *auctionrunner.Cell, task *rep.Task, _ float64) (float64, error) {
+	state := cell.State()
+	if err := state.ResourceMatch(&task.Resource); err != nil {
+		return 0, err
+	}
+	return s.scores[cell.Guid], nil
+}
+
+var _ = Describe("Scorer", func() {
+	var (
+		client      *repfakes.FakeSimClient
+		emptyCell   *auctionrunner.Cell
+		crowdedCell *auctionrunner.Cell
+	)
+
+	BeforeEach(func() {
+		client = &repfakes.FakeSimClient{}
+		emptyState := BuildCellState("empty-cell", "the-zone", 100, 200, 50, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)
+		emptyCell = auctionrunner.NewCell(logger, "empty-cell", client, emptyState)
+
+		crowdedState := BuildCellState("crowded-cell", "the-zone", 100, 200, 50, false, 0, linuxOnlyRootFSProviders, []rep.LRP{
+			*BuildLRP("pg-1", "domain", 0, linuxRootFSURL, 10, 20, 10, []string{}),
+			*BuildLRP("pg-2", "domain", 0, linuxRootFSURL, 10, 20, 10, []string{}),
+		}, []string{}, []string{}, []string{}, 0)
+		crowdedCell = auctionrunner.NewCell(logger, "crowded-cell", client, crowdedState)
+	})
+
+	Describe("the default scorer", func() {
+		var scorer auctionrunner.Scorer
+
+		BeforeEach(func() {
+			scorer = auctionrunner.NewDefaultScorer()
+		})
+
+		It("scores LRPs the same way as the cell", func() {
+			lrp := BuildLRP("pg-1", "domain", 1, linuxRootFSURL, 10, 10, 10, []string{})
+
+			for _, cell := range []*auctionrunner.Cell{emptyCell, crowdedCell} {
+				expected, err := cell.ScoreForLRP(lrp, 0.25)
+				Expect(err).NotTo(HaveOccurred())
+
+				score, err := scorer.ScoreForLRP(cell, lrp, 0.25)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(score).To(Equal(expected))
+			}
+		})
+
+		It("scores tasks the same way as the cell", func() {
+			task := BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{})
+
+			for _, cell := range []*auctionrunner.Cell{emptyCell, crowdedCell} {
+				expected, err := cell.ScoreForTask(task, 0.25)
+				Expect(err).NotTo(HaveOccurred())
+
+				score, err := scorer.ScoreForTask(cell, task, 0.25)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(score).To(Equal(expected))
+			}
+		})
+
+		It("errors when the work does not fit", func() {
+			lrp := BuildLRP("pg-big", "domain", 0, linuxRootFSURL, 1000, 10, 10, []string{})
+
+			_, err := scorer.ScoreForLRP(emptyCell, lrp, 0.25)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("the bin pack scorer", func() {
+		var scorer auctionrunner.Scorer
+
+		BeforeEach(func() {
+			scorer = auctionrunner.NewBinPackScorer()
+		})
+
+		It("prefers the most allocated cell for LRPs", func() {
+			lrp := BuildLRP("pg-3", "domain", 0, linuxRootFSURL, 10, 10, 10, []string{})
+
+			emptyScore, err := scorer.ScoreForLRP(emptyCell, lrp, 0.0)
+			Expect(err).NotTo(HaveOccurred())
+			crowdedScore, err := scorer.ScoreForLRP(crowdedCell, lrp, 0.0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(crowdedScore).To(BeNumerically("<", emptyScore))
+		})
+
+		It("prefers the most allocated cell for tasks", func() {
+			task := BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{})
+
+			emptyScore, err := scorer.ScoreForTask(emptyCell, task, 0.0)
+			Expect(err).NotTo(HaveOccurred())
+			crowdedScore, err := scorer.ScoreForTask(crowdedCell, task, 0.0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(crowdedScore).To(BeNumerically("<", emptyScore))
+		})
+
+		It("keeps instances of the same LRP on different cells", func() {
+			lrp := BuildLRP("pg-1", "domain", 1, linuxRootFSURL, 10, 10, 10, []string{})
+
+			emptyScore, err := scorer.ScoreForLRP(emptyCell, lrp, 0.0)
+			Expect(err).NotTo(HaveOccurred())
+			crowdedScore, err := scorer.ScoreForLRP(crowdedCell, lrp, 0.0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(emptyScore).To(BeNumerically("<", crowdedScore))
+		})
+
+		It("does not let starting containers push work onto emptier cells", func() {
+			startingState := BuildCellState("starting-cell", "the-zone", 100, 200, 50, false, 10, linuxOnlyRootFSProviders, []rep.LRP{
+				*BuildLRP("pg-1", "domain", 0, linuxRootFSURL, 10, 20, 10, []string{}),
+			}, []string{}, []string{}, []string{}, 0)
+			startingCell := auctionrunner.NewCell(logger, "starting-cell", client, startingState)
+			lrp := BuildLRP("pg-3", "domain", 0, linuxRootFSURL, 10, 10, 10, []string{})
+
+			emptyScore, err := scorer.ScoreForLRP(emptyCell, lrp, 0.25)
+			Expect(err).NotTo(HaveOccurred())
+			startingScore, err := scorer.ScoreForLRP(startingCell, lrp, 0.25)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(startingScore).To(BeNumerically("<", emptyScore))
+		})
+
+		It("errors when the work does not fit", func() {
+			lrp := BuildLRP("pg-big", "domain", 0, linuxRootFSURL, 1000, 10, 10, []string{})
+			_, err := scorer.ScoreForLRP(crowdedCell, lrp, 0.0)
+			Expect(err).To(HaveOccurred())
+
+			task := BuildTask("tg-big", "domain", linuxRootFSURL, 1000, 10, 10, []string{}, []string{})
+			_, err = scorer.ScoreForTask(crowdedCell, task, 0.0)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("plugging a scorer into the scheduler", func() {
+		var (
+			workPool *workpool.WorkPool
+			clients  map[string]*repfakes.FakeSimClient
+			zones    map[string]auctionrunner.Zone
+			clock    *fakeclock.FakeClock
+		)
+
+		BeforeEach(func() {
+			var err error
+			workPool, err = workpool.NewWorkPool(5)
+			Expect(err).NotTo(HaveOccurred())
+
+			clock = fakeclock.NewFakeClock(time.Now())
+			clients = map[string]*repfakes.FakeSimClient{
+				"empty-cell":   &repfakes.FakeSimClient{},
+				"crowded-cell": &repfakes.FakeSimClient{},
+			}
+
+			zones = map[string]auctionrunner.Zone{
+				"the-zone": auctionrunner.Zone{
+					auctionrunner.NewCell(logger, "empty-cell", clients["empty-cell"], emptyCell.State()),
+					auctionrunner.NewCell(logger, "crowded-cell", clients["crowded-cell"], crowdedCell.State()),
+				},
+			}
+		})
+
+		AfterEach(func() {
+			workPool.Stop()
+		})
+
+		It("places work on the cell with the lowest score from the scorer", func() {
+			scorer := fixedScorer{scores: map[string]float64{"empty-cell": 2, "crowded-cell": 1}}
+			scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0, auctionrunner.WithScorer(scorer))
+
+			lrpAuction := BuildLRPAuction("pg-3", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{})
+			taskAuction := BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 10, 10, 10, []string{}, []string{}), clock.Now())
+
+			results := scheduler.Schedule(auctiontypes.AuctionRequest{
+				LRPs:  []auctiontypes.LRPAuction{lrpAuction},
+				Tasks: []auctiontypes.TaskAuction{taskAuction},
+			})
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Winner).To(Equal("crowded-cell"))
+			Expect(results.SuccessfulTasks).To(HaveLen(1))
+			Expect(results.SuccessfulTasks[0].Winner).To(Equal("crowded-cell"))
+
+			Expect(clients["empty-cell"].PerformCallCount()).To(Equal(0))
+			Expect(clients["crowded-cell"].PerformCallCount()).To(Equal(1))
+		})
+
+		It("scores with the default scorer when given a nil scorer", func() {
+			scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0, auctionrunner.WithScorer(nil))
+			lrpAuction := BuildLRPAuction("pg-3", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{})
+
+			results := scheduler.Schedule(auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{lrpAuction}})
+			Expect(results.SuccessfulLRPs).To(HaveLen(1))
+			Expect(results.SuccessfulLRPs[0].Winner).To(Equal("empty-cell"))
+		})
+
+		Context("when bin packing", func() {
+			BeforeEach(func() {
+				clients["other-zone-cell"] = &repfakes.FakeSimClient{}
+				otherZoneState := BuildCellState("other-zone-cell", "other-zone", 100, 200, 50, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)
+				zones["other-zone"] = auctionrunner.Zone{
+					auctionrunner.NewCell(logger, "other-zone-cell", clients["other-zone-cell"], otherZoneState),
+				}
+			})
+
+			It("packs new work onto the most allocated cell", func() {
+				scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0, auctionrunner.WithScorer(auctionrunner.NewBinPackScorer()))
+				lrpAuction := BuildLRPAuction("pg-3", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{})
+
+				results := scheduler.Schedule(auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{lrpAuction}})
+				Expect(results.SuccessfulLRPs).To(HaveLen(1))
+				Expect(results.SuccessfulLRPs[0].Winner).To(Equal("crowded-cell"))
+			})
+
+			It("still balances instances across zones", func() {
+				scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0, auctionrunner.WithScorer(auctionrunner.NewBinPackScorer()))
+				lrpAuction := BuildLRPAuction("pg-1", "domain", 1, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{})
+
+				results := scheduler.Schedule(auctiontypes.AuctionRequest{LRPs: []auctiontypes.LRPAuction{lrpAuction}})
+				Expect(results.SuccessfulLRPs).To(HaveLen(1))
+				Expect(results.SuccessfulLRPs[0].Winner).To(Equal("other-zone-cell"))
+			})
+		})
+	})
+})
@@ -0,0 +1,78 @@
+package auctionrunner
+
+import (
+	"context"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/lager"
+)
+
+// A ShutdownMode decides what happens to the auctions still queued in the
+// batch when the runner is signalled.
+type ShutdownMode int
+
+const (
+	// ShutdownDiscard cancels the auction in progress and drops the queued
+	// auctions.
+	ShutdownDiscard ShutdownMode = iota
+	// ShutdownDrain lets the auction in progress finish and runs one final
+	// auction for the queued auctions.
+	ShutdownDrain
+	// ShutdownHandBack cancels the auction in progress and hands the queued
+	// auctions back to the delegate.
+	ShutdownHandBack
+)
+
+// WithShutdownMode sets what the runner does with queued auctions when it is
+// signalled. Auctions that are left over after draining, or that cannot be
+// drained, are handed back to delegates that implement
+// auctiontypes.AuctionHandBackDelegate.
+func WithShutdownMode(mode ShutdownMode) Option {
+	return func(a *auctionRunner) {
+		a.shutdownMode = mode
+	}
+}
+
+func (a *auctionRunner) shutdown() {
+	logger := a.logger.Session("shutdown")
+
+	if a.retryPolicy != nil {
+		a.stopRetrying()
+	}
+
+	if a.shutdownMode == ShutdownDrain && a.batch.Len() > 0 {
+		logger.Info("draining")
+		ctx, cancel := a.auctionContext(context.Background())
+		a.auction(ctx)
+		cancel()
+		logger.Info("drained")
+	}
+
+	var lrpAuctions []auctiontypes.LRPAuction
+	var taskAuctions []auctiontypes.TaskAuction
+	for a.batch.Len() > 0 {
+		lrps, tasks := a.batch.DedupeAndDrain()
+		lrpAuctions = append(lrpAuctions, lrps...)
+		taskAuctions = append(taskAuctions, tasks...)
+	}
+	if len(lrpAuctions) == 0 && len(taskAuctions) == 0 {
+		return
+	}
+
+	data := lager.Data{
+		"lrp-start-auctions": len(lrpAuctions),
+		"task-auctions":      len(taskAuctions),
+	}
+
+	handBack, ok := a.delegate.(auctiontypes.AuctionHandBackDelegate)
+	if a.shutdownMode == ShutdownDiscard || !ok {
+		logger.Info("discarding-queued-auctions", data)
+		return
+	}
+
+	logger.Info("handing-back-queued-auctions", data)
+	handBack.AuctionsHandedBack(lrpAuctions, taskAuctions)
+	// the delegate owns the auctions now, so they are not queued again after a
+	// restart
+	a.batch.Completed(lrpAuctions, taskAuctions)
+}
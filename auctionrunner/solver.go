@@ -0,0 +1,449 @@
+package auctionrunner
+
+import (
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/rep"
+)
+
+// WithSolver makes the scheduler search, for at most budget, for a placement
+// of the whole auction request that beats the greedy one: one that places more
+// work, higher priorities first, or the same work with a lower total score.
+// When no better placement is found in time, the greedy placement is used.
+//
+// Requests with task groups, and schedulers that preempt, are always placed
+// greedily.
+func WithSolver(budget time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.solverBudget = budget
+	}
+}
+
+// defaultSolverNodeLimit bounds the search independently of the clock, which
+// may not move while the solver runs.
+const defaultSolverNodeLimit = 100000
+
+// WithSolverNodeLimit stops the solver's search after it has visited limit
+// partial placements, whether or not its budget has run out.
+func WithSolverNodeLimit(limit int) SchedulerOption {
+	return func(s *Scheduler) {
+		s.solverNodeLimit = limit
+	}
+}
+
+// solverWork is one LRP or task auction, in the order the greedy scheduler
+// auctions them.
+type solverWork struct {
+	lrp  *auctiontypes.LRPAuction
+	task *auctiontypes.TaskAuction
+}
+
+func (w solverWork) priority() auctiontypes.Priority {
+	if w.lrp != nil {
+		return w.lrp.Priority
+	}
+	return w.task.Priority
+}
+
+type solution struct {
+	winners []string // cell guid per work, empty when not placed
+	placed  map[auctiontypes.Priority]int
+	score   float64
+}
+
+func (s solution) copy() solution {
+	placed := make(map[auctiontypes.Priority]int, len(s.placed))
+	for priority, count := range s.placed {
+		placed[priority] = count
+	}
+	return solution{
+		winners: append([]string{}, s.winners...),
+		placed:  placed,
+		score:   s.score,
+	}
+}
+
+// comparePlaced compares the work placed at each priority, highest first.
+func comparePlaced(a, b map[auctiontypes.Priority]int) int {
+	priorities := []auctiontypes.Priority{}
+	for priority := range a {
+		priorities = append(priorities, priority)
+	}
+	for priority := range b {
+		if _, ok := a[priority]; !ok {
+			priorities = append(priorities, priority)
+		}
+	}
+	sort.Slice(priorities, func(i, j int) bool { return priorities[i] > priorities[j] })
+
+	for _, priority := range priorities {
+		switch {
+		case a[priority] > b[priority]:
+			return 1
+		case a[priority] < b[priority]:
+			return -1
+		}
+	}
+	return 0
+}
+
+func (s solution) beats(other solution) bool {
+	if c := comparePlaced(s.placed, other.placed); c != 0 {
+		return c > 0
+	}
+	// ignore differences in the order scores were summed in
+	return s.score < other.score-1e-9
+}
+
+type solver struct {
+	scheduler  *Scheduler
+	zones      map[string]Zone
+	work       []solverWork
+	remaining  []map[auctiontypes.Priority]int // work from each index on, by priority
+	deadline   time.Time
+	nodeLimit  int
+	nodes      int
+	inflight   int
+	timedOut   bool
+	outOfNodes bool
+	improved   bool
+
+	current solution
+	best    solution
+}
+
+// solve looks for a placement that beats the greedy one. It reports false
+// when the request should be placed greedily.
+func (s *Scheduler) solve(auctionRequest auctiontypes.AuctionRequest) (placements, bool) {
+	if s.preemption != nil {
+		return placements{}, false
+	}
+	for i := range auctionRequest.Tasks {
+		if auctionRequest.Tasks[i].Group != "" {
+			return placements{}, false
+		}
+	}
+
+	sort.Sort(SortableLRPAuctions(auctionRequest.LRPs))
+	sort.Sort(SortableTaskAuctions(auctionRequest.Tasks))
+	work := orderedWork(auctionRequest.LRPs, auctionRequest.Tasks)
+
+	greedy := *s
+	greedy.zones = copyZones(s.zones)
+	greedy.solverBudget = 0
+	greedy.explanation = nil
+	greedyPlacements := greedy.place(auctiontypes.AuctionRequest{
+		LRPs:  append([]auctiontypes.LRPAuction{}, auctionRequest.LRPs...),
+		Tasks: append([]auctiontypes.TaskAuction{}, auctionRequest.Tasks...),
+	})
+
+	inflight := 0
+	for _, zone := range s.zones {
+		for _, cell := range zone {
+			inflight += cell.StartingContainerCount()
+		}
+	}
+
+	sv := &solver{
+		scheduler: s,
+		work:      work,
+		remaining: remainingWork(work),
+		deadline:  s.clock.Now().Add(s.solverBudget),
+		nodeLimit: s.solverNodeLimit,
+		inflight:  inflight,
+		current: solution{
+			winners: make([]string, len(work)),
+			placed:  map[auctiontypes.Priority]int{},
+		},
+	}
+	if sv.nodeLimit <= 0 {
+		sv.nodeLimit = defaultSolverNodeLimit
+	}
+	sv.best = sv.replay(copyZones(s.zones), greedyPlacements)
+
+	sv.zones = copyZones(s.zones)
+	sv.search(0)
+
+	if !sv.improved {
+		s.logger.Info("solver-kept-greedy-placement", lager.Data{"timed-out": sv.timedOut, "node-limit-reached": sv.outOfNodes})
+		return placements{}, false
+	}
+
+	s.logger.Info("solver-found-better-placement", lager.Data{"timed-out": sv.timedOut, "node-limit-reached": sv.outOfNodes, "score": sv.best.score})
+	return s.apply(work, sv.best, greedyPlacements), true
+}
+
+// orderedWork lists the sorted auctions in the order place auctions them.
+func orderedWork(lrps []auctiontypes.LRPAuction, tasks []auctiontypes.TaskAuction) []solverWork {
+	work := []solverWork{}
+	appendLRPs := func(lrps []auctiontypes.LRPAuction) {
+		for i := range lrps {
+			work = append(work, solverWork{lrp: &lrps[i]})
+		}
+	}
+
+	for len(lrps) > 0 || len(tasks) > 0 {
+		priority := highestPriority(lrps, tasks)
+
+		var lrpsAtPriority []auctiontypes.LRPAuction
+		var tasksAtPriority []auctiontypes.TaskAuction
+		lrpsAtPriority, lrps = splitLRPsAtPriority(lrps, priority)
+		tasksAtPriority, tasks = splitTasksAtPriority(tasks, priority)
+
+		lrpsBeforeTasks, lrpsAfterTasks := splitLRPS(lrpsAtPriority)
+
+		appendLRPs(lrpsBeforeTasks)
+		for i := range tasksAtPriority {
+			work = append(work, solverWork{task: &tasksAtPriority[i]})
+		}
+		appendLRPs(lrpsAfterTasks)
+	}
+
+	return work
+}
+
+func remainingWork(work []solverWork) []map[auctiontypes.Priority]int {
+	remaining := make([]map[auctiontypes.Priority]int, len(work)+1)
+	remaining[len(work)] = map[auctiontypes.Priority]int{}
+	for i := len(work) - 1; i >= 0; i-- {
+		remaining[i] = map[auctiontypes.Priority]int{}
+		for priority, count := range remaining[i+1] {
+			remaining[i][priority] = count
+		}
+		remaining[i][work[i].priority()]++
+	}
+	return remaining
+}
+
+// replay scores the greedy placement the same way the search scores its own.
+func (sv *solver) replay(zones map[string]Zone, greedy placements) solution {
+	sv.zones = zones
+	replayed := solution{
+		winners: make([]string, len(sv.work)),
+		placed:  map[auctiontypes.Priority]int{},
+	}
+
+	for i, w := range sv.work {
+		var winner string
+		if w.lrp != nil {
+			if successful, ok := greedy.successfulLRPs[w.lrp.Identifier()]; ok {
+				winner = successful.Winner
+			}
+		} else if successful, ok := greedy.successfulTasks[w.task.Identifier()]; ok {
+			winner = successful.Winner
+		}
+
+		cell := findCell(sv.zones, winner)
+		if cell == nil {
+			continue
+		}
+
+		score, err := sv.score(cell, w)
+		if err != nil || sv.reserve(cell, w) != nil {
+			continue
+		}
+		replayed.winners[i] = winner
+		replayed.placed[w.priority()]++
+		replayed.score += score
+	}
+
+	return replayed
+}
+
+func (sv *solver) search(i int) {
+	if sv.scheduler.clock.Now().After(sv.deadline) {
+		sv.timedOut = true
+		return
+	}
+	sv.nodes++
+	if sv.nodes > sv.nodeLimit {
+		sv.outOfNodes = true
+		return
+	}
+
+	if i == len(sv.work) {
+		if sv.current.beats(sv.best) {
+			sv.best = sv.current.copy()
+			sv.improved = true
+		}
+		return
+	}
+
+	optimistic := map[auctiontypes.Priority]int{}
+	for priority, count := range sv.current.placed {
+		optimistic[priority] = count
+	}
+	for priority, count := range sv.remaining[i] {
+		optimistic[priority] += count
+	}
+	if comparePlaced(optimistic, sv.best.placed) < 0 {
+		return
+	}
+
+	w := sv.work[i]
+	if !sv.scheduler.exceededInflightContainerCreation(sv.inflight) {
+		for _, candidate := range sv.candidates(w) {
+			if sv.reserve(candidate.cell, w) != nil {
+				continue
+			}
+			sv.current.winners[i] = candidate.cell.Guid
+			sv.current.placed[w.priority()]++
+			sv.current.score += candidate.score
+			sv.inflight++
+
+			sv.search(i + 1)
+
+			sv.inflight--
+			sv.current.score -= candidate.score
+			sv.current.placed[w.priority()]--
+			sv.current.winners[i] = ""
+			sv.unreserve(candidate.cell, w)
+
+			if sv.timedOut || sv.outOfNodes {
+				return
+			}
+		}
+	}
+
+	sv.search(i + 1)
+}
+
+type candidate struct {
+	cell  *Cell
+	score float64
+}
+
+// candidates returns the cells the work fits on, best score first. LRPs are
+// kept to the zones with the fewest instances that have room, as greedy does.
+func (sv *solver) candidates(w solverWork) []candidate {
+	candidates := []candidate{}
+	addCandidates := func(zone Zone) {
+		for _, cell := range zone {
+			score, err := sv.score(cell, w)
+			if err == nil {
+				candidates = append(candidates, candidate{cell, score})
+			}
+		}
+	}
+
+	if w.lrp != nil {
+		sortedZones, _, err := lrpZones(sv.zones, w.lrp, nil)
+		if err != nil {
+			return nil
+		}
+
+		for zoneIndex, lrpByZone := range sortedZones {
+			addCandidates(lrpByZone.zone)
+
+			if zoneIndex+1 < len(sortedZones) &&
+				sameInstances(lrpByZone, sortedZones[zoneIndex+1]) {
+				continue
+			}
+			if len(candidates) > 0 {
+				break
+			}
+		}
+	} else {
+		for _, zone := range sv.zones {
+			cells, err := zone.filterCells(w.task.PlacementConstraint)
+			if err == nil {
+				addCandidates(Zone(cells))
+			}
+		}
+	}
+
+	if w.lrp != nil {
+		spread := newTopologySpread(sv.zones, w.lrp.ProcessGuid)
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if c := spread.compare(candidates[i].cell, candidates[j].cell); c != 0 {
+				return c < 0
+			}
+			return candidates[i].score < candidates[j].score
+		})
+		return candidates
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score < candidates[j].score })
+	return candidates
+}
+
+func (sv *solver) score(cell *Cell, w solverWork) (float64, error) {
+	s := sv.scheduler
+	if w.lrp != nil {
+		return s.scoreLRP(cell, w.lrp)
+	}
+	return s.scorer.ScoreForTask(cell, &w.task.Task, s.startingContainerWeight)
+}
+
+func (sv *solver) reserve(cell *Cell, w solverWork) error {
+	if w.lrp != nil {
+		return cell.ReserveLRP(&w.lrp.LRP)
+	}
+	return cell.ReserveTask(&w.task.Task)
+}
+
+func (sv *solver) unreserve(cell *Cell, w solverWork) {
+	if w.lrp != nil {
+		cell.unreserveLRP(&w.lrp.LRP)
+	} else {
+		cell.unreserveTask(&w.task.Task)
+	}
+}
+
+// apply reserves the solution on the scheduler's cells. Work that is not
+// placed keeps the error greedy placement failed it with.
+func (s *Scheduler) apply(work []solverWork, best solution, greedy placements) placements {
+	p := placements{
+		successfulLRPs:        map[string]*auctiontypes.LRPAuction{},
+		lrpStartAuctionLookup: map[string]*auctiontypes.LRPAuction{},
+		successfulTasks:       map[string]*auctiontypes.TaskAuction{},
+		taskAuctionLookup:     map[string]*auctiontypes.TaskAuction{},
+	}
+
+	for i, w := range work {
+		var err error
+		if cell := findCell(s.zones, best.winners[i]); cell != nil {
+			if w.lrp != nil {
+				err = cell.ReserveLRP(&w.lrp.LRP)
+			} else {
+				err = cell.ReserveTask(&w.task.Task)
+			}
+		} else {
+			err = rep.InsufficientResourcesError{}
+		}
+
+		if w.lrp != nil {
+			p.lrpStartAuctionLookup[w.lrp.Identifier()] = w.lrp
+			if err != nil {
+				w.lrp.PlacementError = placementError(greedy.lrpStartAuctionLookup[w.lrp.Identifier()].PlacementError, err)
+				p.results.FailedLRPs = append(p.results.FailedLRPs, *w.lrp)
+				continue
+			}
+			winningAuction := w.lrp.Copy()
+			winningAuction.Winner = best.winners[i]
+			p.successfulLRPs[winningAuction.Identifier()] = &winningAuction
+		} else {
+			p.taskAuctionLookup[w.task.Identifier()] = w.task
+			if err != nil {
+				w.task.PlacementError = placementError(greedy.taskAuctionLookup[w.task.Identifier()].PlacementError, err)
+				p.results.FailedTasks = append(p.results.FailedTasks, *w.task)
+				continue
+			}
+			winningAuction := w.task.Copy()
+			winningAuction.Winner = best.winners[i]
+			p.successfulTasks[winningAuction.Identifier()] = &winningAuction
+		}
+	}
+
+	return p
+}
+
+func placementError(greedyError string, err error) string {
+	if greedyError != "" {
+		return greedyError
+	}
+	return err.Error()
+}
@@ -0,0 +1,177 @@
+package auctionrunner_test
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/lagertest"
+	"code.cloudfoundry.org/workpool"
+
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/rep"
+	"code.cloudfoundry.org/rep/repfakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+// slowScorer advances the clock every time it scores.
+type slowScorer struct {
+	auctionrunner.Scorer
+	clock *fakeclock.FakeClock
+}
+
+func (s slowScorer) ScoreForTask(cell *auctionrunner.Cell, task *rep.Task, startingContainerWeight float64) (float64, error) {
+	s.clock.Increment(time.Second)
+	return s.Scorer.ScoreForTask(cell, task, startingContainerWeight)
+}
+
+var _ = Describe("Solver", func() {
+	var (
+		clients      map[string]*repfakes.FakeSimClient
+		zones        map[string]auctionrunner.Zone
+		clock        *fakeclock.FakeClock
+		workPool     *workpool.WorkPool
+		logger       *lagertest.TestLogger
+		options      []auctionrunner.SchedulerOption
+		taskAuctions []auctiontypes.TaskAuction
+		results      auctiontypes.AuctionResults
+	)
+
+	BeforeEach(func() {
+		clock = fakeclock.NewFakeClock(time.Now())
+
+		var err error
+		workPool, err = workpool.NewWorkPool(5)
+		Expect(err).NotTo(HaveOccurred())
+
+		logger = lagertest.NewTestLogger("solver")
+		options = []auctionrunner.SchedulerOption{auctionrunner.WithSolver(time.Second)}
+
+		clients = map[string]*repfakes.FakeSimClient{
+			"A-cell": &repfakes.FakeSimClient{},
+			"B-cell": &repfakes.FakeSimClient{},
+		}
+		zones = map[string]auctionrunner.Zone{
+			"A-zone": auctionrunner.Zone{
+				auctionrunner.NewCell(logger, "A-cell", clients["A-cell"], BuildCellState("A-cell", "A-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+			},
+			"B-zone": auctionrunner.Zone{
+				auctionrunner.NewCell(logger, "B-cell", clients["B-cell"], BuildCellState("B-cell", "B-zone", 100, 100, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0)),
+			},
+		}
+
+		// spreading places 50+40 and 50+30 and has no room left for the last 30
+		taskAuctions = []auctiontypes.TaskAuction{
+			BuildTaskAuction(BuildTask("tg-1", "domain", linuxRootFSURL, 50, 10, 10, []string{}, []string{}), clock.Now()),
+			BuildTaskAuction(BuildTask("tg-2", "domain", linuxRootFSURL, 50, 10, 10, []string{}, []string{}), clock.Now()),
+			BuildTaskAuction(BuildTask("tg-3", "domain", linuxRootFSURL, 40, 10, 10, []string{}, []string{}), clock.Now()),
+			BuildTaskAuction(BuildTask("tg-4", "domain", linuxRootFSURL, 30, 10, 10, []string{}, []string{}), clock.Now()),
+			BuildTaskAuction(BuildTask("tg-5", "domain", linuxRootFSURL, 30, 10, 10, []string{}, []string{}), clock.Now()),
+		}
+	})
+
+	JustBeforeEach(func() {
+		scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0, options...)
+		results = scheduler.Schedule(auctiontypes.AuctionRequest{Tasks: taskAuctions})
+	})
+
+	AfterEach(func() {
+		workPool.Stop()
+	})
+
+	It("places work that greedy scheduling leaves out", func() {
+		Expect(results.SuccessfulTasks).To(HaveLen(5))
+		Expect(results.FailedTasks).To(BeEmpty())
+	})
+
+	It("commits the placement to the cells", func() {
+		Expect(clients["A-cell"].PerformCallCount()).To(Equal(1))
+		Expect(clients["B-cell"].PerformCallCount()).To(Equal(1))
+
+		_, workToA := clients["A-cell"].PerformArgsForCall(0)
+		_, workToB := clients["B-cell"].PerformArgsForCall(0)
+		Expect(len(workToA.Tasks) + len(workToB.Tasks)).To(Equal(5))
+	})
+
+	Context("without a solver", func() {
+		BeforeEach(func() {
+			options = nil
+		})
+
+		It("places greedily", func() {
+			Expect(results.SuccessfulTasks).To(HaveLen(4))
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(results.FailedTasks[0].PlacementError).To(Equal("insufficient resources: memory"))
+		})
+	})
+
+	Context("when the budget runs out", func() {
+		BeforeEach(func() {
+			options = append(options, auctionrunner.WithScorer(slowScorer{Scorer: auctionrunner.NewDefaultScorer(), clock: clock}))
+		})
+
+		It("falls back to the greedy placement", func() {
+			Expect(results.SuccessfulTasks).To(HaveLen(4))
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(results.FailedTasks[0].PlacementError).To(Equal("insufficient resources: memory"))
+		})
+	})
+
+	Context("when the node limit runs out before the clock moves", func() {
+		BeforeEach(func() {
+			options = append(options, auctionrunner.WithSolverNodeLimit(3))
+		})
+
+		It("falls back to the greedy placement", func() {
+			Expect(results.SuccessfulTasks).To(HaveLen(4))
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(logger).To(gbytes.Say("solver-kept-greedy-placement.*node-limit-reached\":true"))
+		})
+	})
+
+	Context("when greedy placement can not be beaten", func() {
+		BeforeEach(func() {
+			taskAuctions = taskAuctions[:2]
+		})
+
+		It("keeps the greedy placement", func() {
+			Expect(results.SuccessfulTasks).To(HaveLen(2))
+			Expect(results.SuccessfulTasks[0].Winner).NotTo(Equal(results.SuccessfulTasks[1].Winner))
+		})
+	})
+
+	Context("when some work does not fit on any cell", func() {
+		BeforeEach(func() {
+			taskAuctions = append(taskAuctions, BuildTaskAuction(BuildTask("tg-6", "domain", linuxRootFSURL, 110, 10, 10, []string{}, []string{}), clock.Now()))
+		})
+
+		It("keeps the placement error greedy placement reported", func() {
+			Expect(results.SuccessfulTasks).To(HaveLen(5))
+			Expect(results.FailedTasks).To(HaveLen(1))
+			Expect(results.FailedTasks[0].TaskGuid).To(Equal("tg-6"))
+			Expect(results.FailedTasks[0].PlacementError).To(Equal("insufficient resources: memory"))
+		})
+	})
+
+	Context("when placing LRPs", func() {
+		BeforeEach(func() {
+			taskAuctions = nil
+		})
+
+		It("keeps the instances balanced across zones", func() {
+			scheduler := auctionrunner.NewScheduler(workPool, zones, clock, logger, 0.0, 0, options...)
+			results = scheduler.Schedule(auctiontypes.AuctionRequest{
+				LRPs: []auctiontypes.LRPAuction{
+					BuildLRPAuction("pg-1", "domain", 0, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{}),
+					BuildLRPAuction("pg-1", "domain", 1, linuxRootFSURL, 10, 10, 10, clock.Now(), nil, []string{}),
+				},
+			})
+
+			Expect(results.SuccessfulLRPs).To(HaveLen(2))
+			Expect(results.SuccessfulLRPs[0].Winner).NotTo(Equal(results.SuccessfulLRPs[1].Winner))
+		})
+	})
+})
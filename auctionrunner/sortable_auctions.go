@@ -13,6 +13,10 @@ func (a SortableLRPAuctions) Swap(i, j int) {
 }
 
 func (a SortableLRPAuctions) Less(i, j int) bool {
+	if a[i].Priority != a[j].Priority {
+		return a[i].Priority > a[j].Priority
+	}
+
 	if a[i].Index == a[j].Index {
 		return a[i].MemoryMB > a[j].MemoryMB
 	}
@@ -31,5 +35,9 @@ func (a SortableTaskAuctions) Swap(i, j int) {
 }
 
 func (a SortableTaskAuctions) Less(i, j int) bool {
+	if a[i].Priority != a[j].Priority {
+		return a[i].Priority > a[j].Priority
+	}
+
 	return a[i].MemoryMB > a[j].MemoryMB
 }
@@ -50,6 +50,24 @@ var _ = Describe("Sortable Auctions", func() {
 				}
 			})
 		})
+
+		Context("when LRP priorities differ", func() {
+			BeforeEach(func() {
+				lrps = []auctiontypes.LRPAuction{
+					BuildLRPAuction("pg-6", "domain", 0, "linux", 40, 10, 10, time.Time{}, nil, []string{}),
+					BuildLRPAuction("pg-7", "domain", 2, "linux", 10, 10, 10, time.Time{}, nil, []string{}),
+					BuildLRPAuction("pg-8", "domain", 1, "linux", 10, 10, 10, time.Time{}, nil, []string{}),
+				}
+				lrps[1].Priority = 2
+				lrps[2].Priority = 1
+			})
+
+			It("sorts by priority before index and memory", func() {
+				Expect(lrps[0].ProcessGuid).To(Equal("pg-7"))
+				Expect(lrps[1].ProcessGuid).To(Equal("pg-8"))
+				Expect(lrps[2].ProcessGuid).To(Equal("pg-6"))
+			})
+		})
 	})
 
 	Describe("Task Auctions", func() {
@@ -72,5 +90,17 @@ var _ = Describe("Sortable Auctions", func() {
 			Expect(tasks[2].Task.TaskGuid).To((Equal("tg-7")))
 			Expect(tasks[3].Task.TaskGuid).To((Equal("tg-6")))
 		})
+
+		Context("when task priorities differ", func() {
+			BeforeEach(func() {
+				tasks[3].Priority = 1
+				sort.Sort(auctionrunner.SortableTaskAuctions(tasks))
+			})
+
+			It("sorts by priority before memory", func() {
+				Expect(tasks[0].Task.TaskGuid).To(Equal("tg-6"))
+				Expect(tasks[1].Task.TaskGuid).To(Equal("tg-9"))
+			})
+		})
 	})
 })
@@ -10,6 +10,38 @@ import (
 	. "github.com/onsi/gomega"
 )
 
+// testPrioritizer prioritizes LRPs by process guid and tasks by task guid.
+type testPrioritizer map[string]auctiontypes.Priority
+
+func (p testPrioritizer) LRPPriority(lrp *rep.LRP) auctiontypes.Priority {
+	return p[lrp.ProcessGuid]
+}
+
+func (p testPrioritizer) TaskPriority(task *rep.Task) auctiontypes.Priority {
+	return p[task.TaskGuid]
+}
+
+// testCapper caps LRPs by process guid.
+type testCapper map[string]auctiontypes.InstanceCaps
+
+func (c testCapper) InstanceCaps(lrp *rep.LRP) auctiontypes.InstanceCaps {
+	return c[lrp.ProcessGuid]
+}
+
+// testAffinityAssigner assigns affinity rules by process guid.
+type testAffinityAssigner map[string]auctiontypes.AffinityRules
+
+func (a testAffinityAssigner) AffinityRules(lrp *rep.LRP) auctiontypes.AffinityRules {
+	return a[lrp.ProcessGuid]
+}
+
+// testSpreadAssigner assigns spread constraints by process guid.
+type testSpreadAssigner map[string]auctiontypes.SpreadConstraint
+
+func (a testSpreadAssigner) SpreadConstraint(lrp *rep.LRP) auctiontypes.SpreadConstraint {
+	return a[lrp.ProcessGuid]
+}
+
 func BuildLRPStartRequest(
 	processGuid, domain string,
 	indices []int,
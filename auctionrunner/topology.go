@@ -0,0 +1,98 @@
+package auctionrunner
+
+import (
+	"strings"
+
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/rep"
+)
+
+const (
+	RegionLabelPrefix = "region:"
+	RackLabelPrefix   = "rack:"
+	HostLabelPrefix   = "host:"
+)
+
+// LabelTopologyResolver locates cells from the "region:", "rack:" and "host:"
+// placement tags they report, preferably as optional placement tags.
+type LabelTopologyResolver struct{}
+
+func (LabelTopologyResolver) CellTopology(cellID string, state rep.CellState) auctiontypes.Topology {
+	topology := auctiontypes.Topology{}
+	labels := append(append([]string{}, state.PlacementTags...), state.OptionalPlacementTags...)
+	for _, label := range labels {
+		switch {
+		case strings.HasPrefix(label, RegionLabelPrefix):
+			topology.Region = strings.TrimPrefix(label, RegionLabelPrefix)
+		case strings.HasPrefix(label, RackLabelPrefix):
+			topology.Rack = strings.TrimPrefix(label, RackLabelPrefix)
+		case strings.HasPrefix(label, HostLabelPrefix):
+			topology.Host = strings.TrimPrefix(label, HostLabelPrefix)
+		}
+	}
+	return topology
+}
+
+// MapTopologyResolver locates cells by their id. Cells missing from the map
+// have an empty topology.
+type MapTopologyResolver map[string]auctiontypes.Topology
+
+func (m MapTopologyResolver) CellTopology(cellID string, state rep.CellState) auctiontypes.Topology {
+	return m[cellID]
+}
+
+// ResolveTopology locates every cell of the zones with the resolver.
+func ResolveTopology(zones map[string]Zone, resolver auctiontypes.TopologyResolver) {
+	for _, zone := range zones {
+		for _, cell := range zone {
+			cell.topology = resolver.CellTopology(cell.Guid, cell.state)
+		}
+	}
+}
+
+// topologySpread counts the instances of a process in every rack and on every
+// host, to spread them below the zone level.
+type topologySpread map[string]int
+
+func newTopologySpread(zones map[string]Zone, processGuid string) topologySpread {
+	spread := topologySpread{}
+	for _, zone := range zones {
+		for _, cell := range zone {
+			instances := cell.instancesOf(processGuid)
+			if instances == 0 {
+				continue
+			}
+			if cell.topology.Rack != "" {
+				spread[rackKey(cell)] += instances
+			}
+			if cell.topology.Host != "" {
+				spread[hostKey(cell)] += instances
+			}
+		}
+	}
+	return spread
+}
+
+// compare orders cells by the instances in their rack, then on their host, for
+// the levels both cells are located at.
+func (t topologySpread) compare(a, b *Cell) int {
+	if a.topology.Rack != "" && b.topology.Rack != "" {
+		if c := t[rackKey(a)] - t[rackKey(b)]; c != 0 {
+			return c
+		}
+	}
+	if a.topology.Host != "" && b.topology.Host != "" {
+		if c := t[hostKey(a)] - t[hostKey(b)]; c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func rackKey(cell *Cell) string {
+	return cell.state.Zone + "/" + cell.topology.Rack
+}
+
+func hostKey(cell *Cell) string {
+	return rackKey(cell) + "/" + cell.topology.Host
+}
@@ -0,0 +1,42 @@
+package auctionrunner_test
+
+import (
+	"code.cloudfoundry.org/auction/auctionrunner"
+	"code.cloudfoundry.org/auction/auctiontypes"
+	"code.cloudfoundry.org/rep"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Topology", func() {
+	Describe("LabelTopologyResolver", func() {
+		It("reads the topology from placement tags", func() {
+			state := rep.CellState{
+				PlacementTags:         []string{"region:east", "gpu"},
+				OptionalPlacementTags: []string{"rack:r1", "host:h1"},
+			}
+
+			topology := auctionrunner.LabelTopologyResolver{}.CellTopology("cell-1", state)
+			Expect(topology).To(Equal(auctiontypes.Topology{Region: "east", Rack: "r1", Host: "h1"}))
+		})
+
+		It("leaves levels without a tag empty", func() {
+			state := rep.CellState{PlacementTags: []string{"rack:r1"}}
+
+			topology := auctionrunner.LabelTopologyResolver{}.CellTopology("cell-1", state)
+			Expect(topology).To(Equal(auctiontypes.Topology{Rack: "r1"}))
+		})
+	})
+
+	Describe("MapTopologyResolver", func() {
+		It("locates cells by id", func() {
+			resolver := auctionrunner.MapTopologyResolver{
+				"cell-1": {Region: "east", Rack: "r1"},
+			}
+
+			Expect(resolver.CellTopology("cell-1", rep.CellState{})).To(Equal(auctiontypes.Topology{Region: "east", Rack: "r1"}))
+			Expect(resolver.CellTopology("cell-2", rep.CellState{})).To(Equal(auctiontypes.Topology{}))
+		})
+	})
+})
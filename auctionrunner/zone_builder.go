@@ -1,7 +1,7 @@
 package auctionrunner
 
 import (
-	"sync"
+	"context"
 	"time"
 
 	"code.cloudfoundry.org/auction/auctiontypes"
@@ -11,13 +11,20 @@ import (
 )
 
 func FetchStateAndBuildZones(logger lager.Logger, workPool *workpool.WorkPool, clients map[string]rep.Client, metricEmitter auctiontypes.AuctionMetricEmitterDelegate) map[string]Zone {
+	return FetchStateAndBuildZonesContext(context.Background(), logger, workPool, clients, metricEmitter)
+}
+
+// FetchStateAndBuildZonesContext is FetchStateAndBuildZones that stops waiting
+// for cells once ctx is done. Cells that have not reported their state by then
+// are left out of the zones.
+func FetchStateAndBuildZonesContext(ctx context.Context, logger lager.Logger, workPool *workpool.WorkPool, clients map[string]rep.Client, metricEmitter auctiontypes.AuctionMetricEmitterDelegate) map[string]Zone {
 	var zones map[string]Zone
 	for i := 0; ; i++ {
-		zones = fetchStateAndBuildZones(logger, workPool, clients, metricEmitter)
+		zones = fetchStateAndBuildZones(ctx, logger, workPool, clients, metricEmitter)
 		if len(zones) > 0 {
 			break
 		}
-		if i == 3 {
+		if i == 3 || ctx.Err() != nil {
 			logger.Info("failed-to-communicate-to-cells-abort")
 			break
 		}
@@ -26,16 +33,16 @@ func FetchStateAndBuildZones(logger lager.Logger, workPool *workpool.WorkPool, c
 	return zones
 }
 
-func fetchStateAndBuildZones(logger lager.Logger, workPool *workpool.WorkPool, clients map[string]rep.Client, metricEmitter auctiontypes.AuctionMetricEmitterDelegate) map[string]Zone {
-	wg := &sync.WaitGroup{}
+func fetchStateAndBuildZones(ctx context.Context, logger lager.Logger, workPool *workpool.WorkPool, clients map[string]rep.Client, metricEmitter auctiontypes.AuctionMetricEmitterDelegate) map[string]Zone {
 	zones := map[string]Zone{}
-	lock := &sync.Mutex{}
+	// buffered so that cells reporting after ctx is done do not block
+	cells := make(chan *Cell, len(clients))
 
-	wg.Add(len(clients))
 	for guid, client := range clients {
 		guid, client := guid, client
 		workPool.Submit(func() {
-			defer wg.Done()
+			var cell *Cell
+			defer func() { cells <- cell }()
 
 			startTime := time.Now()
 			state, err := client.State(logger)
@@ -55,15 +62,22 @@ func fetchStateAndBuildZones(logger lager.Logger, workPool *workpool.WorkPool, c
 				return
 			}
 
-			cell := NewCell(logger, guid, client, state)
-			lock.Lock()
-			zones[state.Zone] = append(zones[state.Zone], cell)
-			lock.Unlock()
+			cell = NewCell(logger, guid, client, state)
 			logger.Info("fetched-cell-state", lager.Data{"cell-guid": guid, "duration_ns": time.Since(startTime)})
 		})
 	}
 
-	wg.Wait()
+	for range clients {
+		select {
+		case cell := <-cells:
+			if cell != nil {
+				zones[cell.state.Zone] = append(zones[cell.state.Zone], cell)
+			}
+		case <-ctx.Done():
+			logger.Error("dropped-slow-cells", ctx.Err())
+			return zones
+		}
+	}
 
 	return zones
 }
@@ -1,6 +1,7 @@
 package auctionrunner_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -249,6 +250,33 @@ var _ = Describe("ZoneBuilder", func() {
 		})
 	})
 
+	Context("when the context is done before a cell reports its state", func() {
+		var unblock chan struct{}
+
+		BeforeEach(func() {
+			unblock = make(chan struct{})
+			repB.StateStub = func(lager.Logger) (rep.CellState, error) {
+				<-unblock
+				return rep.CellState{}, errors.New("too late")
+			}
+		})
+
+		AfterEach(func() {
+			close(unblock)
+		})
+
+		It("leaves the cell out of the zones", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			zones := auctionrunner.FetchStateAndBuildZonesContext(ctx, logger, workPool, clients, metricEmitter)
+			Expect(zones["the-zone"]).To(HaveLen(1))
+			Expect(zones["the-zone"][0].Guid).To(Equal("A"))
+			Expect(zones["other-zone"]).To(HaveLen(1))
+			Expect(logger.LogMessages()).To(ContainElement("test.dropped-slow-cells"))
+		})
+	})
+
 	Context("when clients are slow to respond", func() {
 		BeforeEach(func() {
 			repA.StateReturns(BuildCellState("A", "the-zone", 10, 10, 100, false, 0, linuxOnlyRootFSProviders, nil, []string{}, []string{}, []string{}, 0), errors.New("timeout"))
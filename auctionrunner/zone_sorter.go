@@ -7,8 +7,9 @@ import (
 )
 
 type lrpByZone struct {
-	zone      Zone
-	instances int
+	zone            Zone
+	instances       int
+	regionInstances int // instances in the zone's region
 }
 
 type zoneSorterByInstances struct {
@@ -17,21 +18,31 @@ type zoneSorterByInstances struct {
 
 func (s zoneSorterByInstances) Len() int           { return len(s.zones) }
 func (s zoneSorterByInstances) Swap(i, j int)      { s.zones[i], s.zones[j] = s.zones[j], s.zones[i] }
-func (s zoneSorterByInstances) Less(i, j int) bool { return s.zones[i].instances < s.zones[j].instances }
+func (s zoneSorterByInstances) Less(i, j int) bool {
+	if s.zones[i].regionInstances != s.zones[j].regionInstances {
+		return s.zones[i].regionInstances < s.zones[j].regionInstances
+	}
+	return s.zones[i].instances < s.zones[j].instances
+}
+
+// sameInstances reports whether two zones tie for the next instance.
+func sameInstances(a, b lrpByZone) bool {
+	return a.regionInstances == b.regionInstances && a.instances == b.instances
+}
 
 func accumulateZonesByInstances(zones map[string]Zone, processGuid string) []lrpByZone {
 	lrpZones := []lrpByZone{}
 
 	for _, zone := range zones {
-		instances := 0
-		for _, cell := range zone {
-			for i := range cell.state.LRPs {
-				if cell.state.LRPs[i].ProcessGuid == processGuid {
-					instances++
-				}
-			}
-		}
-		lrpZones = append(lrpZones, lrpByZone{zone, instances})
+		lrpZones = append(lrpZones, lrpByZone{zone: zone, instances: zone.instancesOf(processGuid)})
+	}
+
+	regionInstances := map[string]int{}
+	for _, lrpZone := range lrpZones {
+		regionInstances[zoneRegion(lrpZone.zone)] += lrpZone.instances
+	}
+	for i := range lrpZones {
+		lrpZones[i].regionInstances = regionInstances[zoneRegion(lrpZones[i].zone)]
 	}
 
 	return lrpZones
@@ -62,8 +73,9 @@ func filterZones(zones []lrpByZone, lrpAuction *auctiontypes.LRPAuction) ([]lrpB
 		}
 
 		filteredZone := lrpByZone{
-			zone:      Zone(cells),
-			instances: lrpZone.instances,
+			zone:            Zone(cells),
+			instances:       lrpZone.instances,
+			regionInstances: lrpZone.regionInstances,
 		}
 		filteredZones = append(filteredZones, filteredZone)
 	}
@@ -74,3 +86,135 @@ func filterZones(zones []lrpByZone, lrpAuction *auctiontypes.LRPAuction) ([]lrpB
 
 	return filteredZones, nil
 }
+
+// filterInstanceCaps removes the zones and cells that already have as many
+// instances of the process as the auction's caps allow.
+func filterInstanceCaps(zones []lrpByZone, lrpAuction *auctiontypes.LRPAuction, explainer *cellExplainer) ([]lrpByZone, error) {
+	caps := lrpAuction.Caps
+	if caps.PerCell <= 0 && caps.PerZone <= 0 {
+		return zones, nil
+	}
+
+	filteredZones := []lrpByZone{}
+	for _, lrpZone := range zones {
+		if caps.PerZone > 0 && lrpZone.instances >= caps.PerZone {
+			explainer.rejectZone(lrpZone.zone, auctiontypes.FilterStageInstanceCaps, auctiontypes.ErrorInstanceCapReached)
+			continue
+		}
+
+		cells := lrpZone.zone.filterInstanceCaps(lrpAuction.ProcessGuid, caps.PerCell)
+		if len(cells) < len(lrpZone.zone) {
+			explainer.rejectZone(lrpZone.zone, auctiontypes.FilterStageInstanceCaps, auctiontypes.ErrorInstanceCapReached, cells...)
+		}
+		if len(cells) == 0 {
+			continue
+		}
+
+		filteredZones = append(filteredZones, lrpByZone{
+			zone:            Zone(cells),
+			instances:       lrpZone.instances,
+			regionInstances: lrpZone.regionInstances,
+		})
+	}
+
+	if len(filteredZones) == 0 {
+		return nil, auctiontypes.ErrorInstanceCapReached
+	}
+
+	return filteredZones, nil
+}
+
+// filterAffinity removes the cells that do not satisfy the auction's required
+// affinity rules.
+func filterAffinity(zones []lrpByZone, lrpAuction *auctiontypes.LRPAuction, explainer *cellExplainer) ([]lrpByZone, error) {
+	filteredZones := []lrpByZone{}
+	for _, lrpZone := range zones {
+		cells := lrpZone.zone.filterAffinity(lrpAuction.Affinity)
+		if len(cells) < len(lrpZone.zone) {
+			explainer.rejectZone(lrpZone.zone, auctiontypes.FilterStageAffinity, auctiontypes.ErrorAffinityMismatch, cells...)
+		}
+		if len(cells) == 0 {
+			continue
+		}
+
+		filteredZones = append(filteredZones, lrpByZone{
+			zone:            Zone(cells),
+			instances:       lrpZone.instances,
+			regionInstances: lrpZone.regionInstances,
+		})
+	}
+
+	if len(filteredZones) == 0 {
+		return nil, auctiontypes.ErrorAffinityMismatch
+	}
+
+	return filteredZones, nil
+}
+
+// applySpread keeps the zones in which an instance stays within the auction's
+// maximum skew of the least populated compatible zone, and makes them tie so
+// that the best cell among them wins. Under SpreadFail the other zones are
+// dropped, and skewed reports whether any were; otherwise they are kept to be
+// tried afterwards.
+func applySpread(zones []lrpByZone, fewestInstances int, lrpAuction *auctiontypes.LRPAuction, explainer *cellExplainer) (spread []lrpByZone, skewed bool) {
+	maxSkew := lrpAuction.Spread.MaxSkew
+	if maxSkew <= 0 {
+		return zones, false
+	}
+
+	spread = make([]lrpByZone, 0, len(zones))
+	for _, lrpZone := range zones {
+		switch {
+		case lrpZone.instances+1-fewestInstances <= maxSkew:
+			spread = append(spread, lrpByZone{zone: lrpZone.zone, instances: fewestInstances, regionInstances: lrpZone.regionInstances})
+		case lrpAuction.Spread.WhenUnsatisfiable == auctiontypes.SpreadFail:
+			explainer.rejectZone(lrpZone.zone, auctiontypes.FilterStageSpread, auctiontypes.ErrorMaxSkewExceeded)
+			skewed = true
+		default:
+			spread = append(spread, lrpZone)
+		}
+	}
+
+	return spread, skewed
+}
+
+// lrpZones returns the zones an LRP may be placed in, in the order they should
+// be tried. skewed reports whether zones were left out to keep the LRP's spread.
+func lrpZones(zones map[string]Zone, lrpAuction *auctiontypes.LRPAuction, explainer *cellExplainer) (sortedZones []lrpByZone, skewed bool, err error) {
+	compatibleZones, err := filterZones(accumulateZonesByInstances(zones, lrpAuction.ProcessGuid), lrpAuction)
+	if err != nil {
+		return nil, false, err
+	}
+
+	filteredZones, err := filterInstanceCaps(compatibleZones, lrpAuction, explainer)
+	if err != nil {
+		return nil, false, err
+	}
+
+	filteredZones, err = filterAffinity(filteredZones, lrpAuction, explainer)
+	if err != nil {
+		return nil, false, err
+	}
+
+	fewestInstances := compatibleZones[0].instances
+	for _, lrpZone := range compatibleZones {
+		if lrpZone.instances < fewestInstances {
+			fewestInstances = lrpZone.instances
+		}
+	}
+
+	filteredZones, skewed = applySpread(filteredZones, fewestInstances, lrpAuction, explainer)
+	if len(filteredZones) == 0 {
+		return nil, true, auctiontypes.ErrorMaxSkewExceeded
+	}
+
+	return sortZonesByInstances(filteredZones), skewed, nil
+}
+
+// zoneRegion is the region of the zone's cells.
+func zoneRegion(zone Zone) string {
+	if len(zone) == 0 {
+		return ""
+	}
+	return zone[0].topology.Region
+}
@@ -7,6 +7,7 @@ import (
 
 	"code.cloudfoundry.org/auction/auctiontypes"
 	"code.cloudfoundry.org/auctioneer"
+	"code.cloudfoundry.org/bbs/models"
 )
 
 type FakeAuctionRunner struct {
@@ -19,16 +20,41 @@ type FakeAuctionRunner struct {
 	runReturns struct {
 		result1 error
 	}
-	ScheduleLRPsForAuctionsStub        func([]auctioneer.LRPStartRequest)
+	ScheduleLRPsForAuctionsStub        func([]auctioneer.LRPStartRequest) error
 	scheduleLRPsForAuctionsMutex       sync.RWMutex
 	scheduleLRPsForAuctionsArgsForCall []struct {
 		arg1 []auctioneer.LRPStartRequest
 	}
-	ScheduleTasksForAuctionsStub        func([]auctioneer.TaskStartRequest)
+	scheduleLRPsForAuctionsReturns struct {
+		result1 error
+	}
+	ScheduleTasksForAuctionsStub        func([]auctioneer.TaskStartRequest) error
 	scheduleTasksForAuctionsMutex       sync.RWMutex
 	scheduleTasksForAuctionsArgsForCall []struct {
 		arg1 []auctioneer.TaskStartRequest
 	}
+	scheduleTasksForAuctionsReturns struct {
+		result1 error
+	}
+	ScheduleTaskGroupForAuctionStub        func(group string, tasks []auctioneer.TaskStartRequest) error
+	scheduleTaskGroupForAuctionMutex       sync.RWMutex
+	scheduleTaskGroupForAuctionArgsForCall []struct {
+		group string
+		tasks []auctioneer.TaskStartRequest
+	}
+	scheduleTaskGroupForAuctionReturns struct {
+		result1 error
+	}
+	CancelLRPAuctionsStub        func(keys []models.ActualLRPKey)
+	cancelLRPAuctionsMutex       sync.RWMutex
+	cancelLRPAuctionsArgsForCall []struct {
+		keys []models.ActualLRPKey
+	}
+	CancelTaskAuctionsStub        func(taskGuids []string)
+	cancelTaskAuctionsMutex       sync.RWMutex
+	cancelTaskAuctionsArgsForCall []struct {
+		taskGuids []string
+	}
 }
 
 func (fake *FakeAuctionRunner) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
@@ -64,14 +90,16 @@ func (fake *FakeAuctionRunner) RunReturns(result1 error) {
 	}{result1}
 }
 
-func (fake *FakeAuctionRunner) ScheduleLRPsForAuctions(arg1 []auctioneer.LRPStartRequest) {
+func (fake *FakeAuctionRunner) ScheduleLRPsForAuctions(arg1 []auctioneer.LRPStartRequest) error {
 	fake.scheduleLRPsForAuctionsMutex.Lock()
 	fake.scheduleLRPsForAuctionsArgsForCall = append(fake.scheduleLRPsForAuctionsArgsForCall, struct {
 		arg1 []auctioneer.LRPStartRequest
 	}{arg1})
 	fake.scheduleLRPsForAuctionsMutex.Unlock()
 	if fake.ScheduleLRPsForAuctionsStub != nil {
-		fake.ScheduleLRPsForAuctionsStub(arg1)
+		return fake.ScheduleLRPsForAuctionsStub(arg1)
+	} else {
+		return fake.scheduleLRPsForAuctionsReturns.result1
 	}
 }
 
@@ -87,14 +115,23 @@ func (fake *FakeAuctionRunner) ScheduleLRPsForAuctionsArgsForCall(i int) []aucti
 	return fake.scheduleLRPsForAuctionsArgsForCall[i].arg1
 }
 
-func (fake *FakeAuctionRunner) ScheduleTasksForAuctions(arg1 []auctioneer.TaskStartRequest) {
+func (fake *FakeAuctionRunner) ScheduleLRPsForAuctionsReturns(result1 error) {
+	fake.ScheduleLRPsForAuctionsStub = nil
+	fake.scheduleLRPsForAuctionsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAuctionRunner) ScheduleTasksForAuctions(arg1 []auctioneer.TaskStartRequest) error {
 	fake.scheduleTasksForAuctionsMutex.Lock()
 	fake.scheduleTasksForAuctionsArgsForCall = append(fake.scheduleTasksForAuctionsArgsForCall, struct {
 		arg1 []auctioneer.TaskStartRequest
 	}{arg1})
 	fake.scheduleTasksForAuctionsMutex.Unlock()
 	if fake.ScheduleTasksForAuctionsStub != nil {
-		fake.ScheduleTasksForAuctionsStub(arg1)
+		return fake.ScheduleTasksForAuctionsStub(arg1)
+	} else {
+		return fake.scheduleTasksForAuctionsReturns.result1
 	}
 }
 
@@ -110,4 +147,90 @@ func (fake *FakeAuctionRunner) ScheduleTasksForAuctionsArgsForCall(i int) []auct
 	return fake.scheduleTasksForAuctionsArgsForCall[i].arg1
 }
 
+func (fake *FakeAuctionRunner) ScheduleTasksForAuctionsReturns(result1 error) {
+	fake.ScheduleTasksForAuctionsStub = nil
+	fake.scheduleTasksForAuctionsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAuctionRunner) ScheduleTaskGroupForAuction(group string, tasks []auctioneer.TaskStartRequest) error {
+	fake.scheduleTaskGroupForAuctionMutex.Lock()
+	fake.scheduleTaskGroupForAuctionArgsForCall = append(fake.scheduleTaskGroupForAuctionArgsForCall, struct {
+		group string
+		tasks []auctioneer.TaskStartRequest
+	}{group, tasks})
+	fake.scheduleTaskGroupForAuctionMutex.Unlock()
+	if fake.ScheduleTaskGroupForAuctionStub != nil {
+		return fake.ScheduleTaskGroupForAuctionStub(group, tasks)
+	} else {
+		return fake.scheduleTaskGroupForAuctionReturns.result1
+	}
+}
+
+func (fake *FakeAuctionRunner) ScheduleTaskGroupForAuctionCallCount() int {
+	fake.scheduleTaskGroupForAuctionMutex.RLock()
+	defer fake.scheduleTaskGroupForAuctionMutex.RUnlock()
+	return len(fake.scheduleTaskGroupForAuctionArgsForCall)
+}
+
+func (fake *FakeAuctionRunner) ScheduleTaskGroupForAuctionArgsForCall(i int) (string, []auctioneer.TaskStartRequest) {
+	fake.scheduleTaskGroupForAuctionMutex.RLock()
+	defer fake.scheduleTaskGroupForAuctionMutex.RUnlock()
+	return fake.scheduleTaskGroupForAuctionArgsForCall[i].group, fake.scheduleTaskGroupForAuctionArgsForCall[i].tasks
+}
+
+func (fake *FakeAuctionRunner) ScheduleTaskGroupForAuctionReturns(result1 error) {
+	fake.ScheduleTaskGroupForAuctionStub = nil
+	fake.scheduleTaskGroupForAuctionReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeAuctionRunner) CancelLRPAuctions(keys []models.ActualLRPKey) {
+	fake.cancelLRPAuctionsMutex.Lock()
+	fake.cancelLRPAuctionsArgsForCall = append(fake.cancelLRPAuctionsArgsForCall, struct {
+		keys []models.ActualLRPKey
+	}{keys})
+	fake.cancelLRPAuctionsMutex.Unlock()
+	if fake.CancelLRPAuctionsStub != nil {
+		fake.CancelLRPAuctionsStub(keys)
+	}
+}
+
+func (fake *FakeAuctionRunner) CancelLRPAuctionsCallCount() int {
+	fake.cancelLRPAuctionsMutex.RLock()
+	defer fake.cancelLRPAuctionsMutex.RUnlock()
+	return len(fake.cancelLRPAuctionsArgsForCall)
+}
+
+func (fake *FakeAuctionRunner) CancelLRPAuctionsArgsForCall(i int) []models.ActualLRPKey {
+	fake.cancelLRPAuctionsMutex.RLock()
+	defer fake.cancelLRPAuctionsMutex.RUnlock()
+	return fake.cancelLRPAuctionsArgsForCall[i].keys
+}
+
+func (fake *FakeAuctionRunner) CancelTaskAuctions(taskGuids []string) {
+	fake.cancelTaskAuctionsMutex.Lock()
+	fake.cancelTaskAuctionsArgsForCall = append(fake.cancelTaskAuctionsArgsForCall, struct {
+		taskGuids []string
+	}{taskGuids})
+	fake.cancelTaskAuctionsMutex.Unlock()
+	if fake.CancelTaskAuctionsStub != nil {
+		fake.CancelTaskAuctionsStub(taskGuids)
+	}
+}
+
+func (fake *FakeAuctionRunner) CancelTaskAuctionsCallCount() int {
+	fake.cancelTaskAuctionsMutex.RLock()
+	defer fake.cancelTaskAuctionsMutex.RUnlock()
+	return len(fake.cancelTaskAuctionsArgsForCall)
+}
+
+func (fake *FakeAuctionRunner) CancelTaskAuctionsArgsForCall(i int) []string {
+	fake.cancelTaskAuctionsMutex.RLock()
+	defer fake.cancelTaskAuctionsMutex.RUnlock()
+	return fake.cancelTaskAuctionsArgsForCall[i].taskGuids
+}
+
 var _ auctiontypes.AuctionRunner = new(FakeAuctionRunner)
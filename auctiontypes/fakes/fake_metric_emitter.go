@@ -25,6 +25,16 @@ type FakeAuctionMetricEmitterDelegate struct {
 	auctionCompletedArgsForCall       []struct {
 		arg1 auctiontypes.AuctionResults
 	}
+	QueueDepthStub        func(int)
+	queueDepthMutex       sync.RWMutex
+	queueDepthArgsForCall []struct {
+		arg1 int
+	}
+	FetchCellRepsFailuresStub        func(consecutiveFailures int)
+	fetchCellRepsFailuresMutex       sync.RWMutex
+	fetchCellRepsFailuresArgsForCall []struct {
+		consecutiveFailures int
+	}
 }
 
 func (fake *FakeAuctionMetricEmitterDelegate) FetchStatesCompleted(arg1 time.Duration) error {
@@ -97,4 +107,50 @@ func (fake *FakeAuctionMetricEmitterDelegate) AuctionCompletedArgsForCall(i int)
 	return fake.auctionCompletedArgsForCall[i].arg1
 }
 
+func (fake *FakeAuctionMetricEmitterDelegate) QueueDepth(arg1 int) {
+	fake.queueDepthMutex.Lock()
+	fake.queueDepthArgsForCall = append(fake.queueDepthArgsForCall, struct {
+		arg1 int
+	}{arg1})
+	fake.queueDepthMutex.Unlock()
+	if fake.QueueDepthStub != nil {
+		fake.QueueDepthStub(arg1)
+	}
+}
+
+func (fake *FakeAuctionMetricEmitterDelegate) QueueDepthCallCount() int {
+	fake.queueDepthMutex.RLock()
+	defer fake.queueDepthMutex.RUnlock()
+	return len(fake.queueDepthArgsForCall)
+}
+
+func (fake *FakeAuctionMetricEmitterDelegate) QueueDepthArgsForCall(i int) int {
+	fake.queueDepthMutex.RLock()
+	defer fake.queueDepthMutex.RUnlock()
+	return fake.queueDepthArgsForCall[i].arg1
+}
+
+func (fake *FakeAuctionMetricEmitterDelegate) FetchCellRepsFailures(consecutiveFailures int) {
+	fake.fetchCellRepsFailuresMutex.Lock()
+	fake.fetchCellRepsFailuresArgsForCall = append(fake.fetchCellRepsFailuresArgsForCall, struct {
+		consecutiveFailures int
+	}{consecutiveFailures})
+	fake.fetchCellRepsFailuresMutex.Unlock()
+	if fake.FetchCellRepsFailuresStub != nil {
+		fake.FetchCellRepsFailuresStub(consecutiveFailures)
+	}
+}
+
+func (fake *FakeAuctionMetricEmitterDelegate) FetchCellRepsFailuresCallCount() int {
+	fake.fetchCellRepsFailuresMutex.RLock()
+	defer fake.fetchCellRepsFailuresMutex.RUnlock()
+	return len(fake.fetchCellRepsFailuresArgsForCall)
+}
+
+func (fake *FakeAuctionMetricEmitterDelegate) FetchCellRepsFailuresArgsForCall(i int) int {
+	fake.fetchCellRepsFailuresMutex.RLock()
+	defer fake.fetchCellRepsFailuresMutex.RUnlock()
+	return fake.fetchCellRepsFailuresArgsForCall[i].consecutiveFailures
+}
+
 var _ auctiontypes.AuctionMetricEmitterDelegate = new(FakeAuctionMetricEmitterDelegate)
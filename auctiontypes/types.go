@@ -2,10 +2,13 @@ package auctiontypes
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 
 	"code.cloudfoundry.org/auctioneer"
+	"code.cloudfoundry.org/bbs/models"
+	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/rep"
 	"github.com/tedsuo/ifrit"
 )
@@ -43,12 +46,35 @@ func (e PlacementTagMismatchError) Error() string {
 var ErrorNothingToStop = errors.New("nothing to stop")
 var ErrorCellCommunication = errors.New("unable to communicate to compatible cells")
 var ErrorExceededInflightCreation = errors.New("waiting to start instance: reached in-flight start limit")
+var ErrorZoneBalance = errors.New("skipped to keep instances balanced across zones")
+var ErrorPreemptionPending = errors.New("waiting for lower priority tasks to be preempted")
+var ErrorTaskGroupIncomplete = errors.New("unable to place every task in the group")
+var ErrorInstanceCapReached = errors.New("found no compatible cell below the instance limit for the process")
+var ErrorAffinityMismatch = errors.New("found no compatible cell that satisfies the affinity rules")
+var ErrorMaxSkewExceeded = errors.New("placing the instance would exceed the maximum skew between zones")
+var ErrorWorkNotStarted = errors.New("cell did not start the work after its commit failed")
+var ErrorReservationExpired = errors.New("cell reservation expired before it was confirmed")
 
 //go:generate counterfeiter -o fakes/fake_auction_runner.go . AuctionRunner
 type AuctionRunner interface {
 	ifrit.Runner
-	ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest)
-	ScheduleTasksForAuctions([]auctioneer.TaskStartRequest)
+	ScheduleLRPsForAuctions([]auctioneer.LRPStartRequest) error
+	ScheduleTasksForAuctions([]auctioneer.TaskStartRequest) error
+	ScheduleTaskGroupForAuction(group string, tasks []auctioneer.TaskStartRequest) error
+	CancelLRPAuctions(keys []models.ActualLRPKey)
+	CancelTaskAuctions(taskGuids []string)
+}
+
+// A BatchFullError is returned when there is no room left in the batch for
+// the auctions being scheduled. None of them are queued.
+type BatchFullError struct {
+	Capacity  int
+	Queued    int
+	Requested int
+}
+
+func (e BatchFullError) Error() string {
+	return fmt.Sprintf("auction batch is full: %d of %d queued, %d requested", e.Queued, e.Capacity, e.Requested)
 }
 
 type AuctionRunnerDelegate interface {
@@ -56,11 +82,32 @@ type AuctionRunnerDelegate interface {
 	AuctionCompleted(AuctionResults)
 }
 
+// An AuctionHandBackDelegate is optionally implemented by an
+// AuctionRunnerDelegate to take back the auctions still queued when the runner
+// shuts down, so that they can be submitted again after a restart.
+type AuctionHandBackDelegate interface {
+	AuctionsHandedBack(lrpAuctions []LRPAuction, taskAuctions []TaskAuction)
+}
+
+// A BatchJournal durably records the auctions added to a batch and the
+// auctions that were completed or cancelled, so that the auctions a previous
+// process never completed can be queued again after a restart. Pending returns
+// those auctions in the order they were added. Added gives every auction a
+// JournalID, which Drained identifies them by, so that draining an auction
+// does not drain a later submission of the same work.
+type BatchJournal interface {
+	Pending() ([]LRPAuction, []TaskAuction)
+	Added(lrpAuctions []LRPAuction, taskAuctions []TaskAuction) error
+	Drained(lrpAuctions []LRPAuction, taskAuctions []TaskAuction)
+}
+
 //go:generate counterfeiter -o fakes/fake_metric_emitter.go . AuctionMetricEmitterDelegate
 type AuctionMetricEmitterDelegate interface {
 	FetchStatesCompleted(time.Duration) error
 	FailedCellStateRequest()
 	AuctionCompleted(AuctionResults)
+	QueueDepth(int)
+	FetchCellRepsFailures(consecutiveFailures int)
 }
 
 type AuctionRequest struct {
@@ -68,18 +115,68 @@ type AuctionRequest struct {
 	Tasks []TaskAuction
 }
 
+// AuctionResults reports the outcome of an auction. Failed members of a task
+// group that failed with ErrorTaskGroupIncomplete keep the Winner they were
+// started on, and must be cancelled there.
 type AuctionResults struct {
 	SuccessfulLRPs  []LRPAuction
 	SuccessfulTasks []TaskAuction
 	FailedLRPs      []LRPAuction
 	FailedTasks     []TaskAuction
+	PreemptedTasks  []PreemptedTask
+
+	// Cancelled while they were being auctioned. Those with a Winner were
+	// started on that cell regardless.
+	CancelledLRPs  []LRPAuction
+	CancelledTasks []TaskAuction
+}
+
+// PreemptedTask is a running task that must be cancelled to make room for the
+// higher priority LRP PreemptedBy on the same cell.
+type PreemptedTask struct {
+	rep.Task
+	CellID      string
+	PreemptedBy string
 }
 
 // LRPStart and Task Auctions
 
+// Priority orders auctions within a batch. Higher priorities are scheduled
+// first; the zero value is the default priority.
+type Priority int
+
+// A Prioritizer assigns priorities to work, both to incoming start requests and
+// to work already running on cells.
+type Prioritizer interface {
+	LRPPriority(lrp *rep.LRP) Priority
+	TaskPriority(task *rep.Task) Priority
+}
+
+// A TaskStartTimeReporter is optionally implemented by a Prioritizer to report
+// when tasks running on cells were started. Preemption uses it to prefer the
+// least recently started victims.
+type TaskStartTimeReporter interface {
+	TaskStartedAt(task *rep.Task) time.Time
+}
+
+// A ReservingClient is optionally implemented by a rep.Client to hold work for
+// a limited time before it is started. Reserve holds the room for the work the
+// cell accepts and returns the rest; Confirm starts the held work and returns
+// ErrorReservationExpired if the hold has lapsed; Release drops the hold.
+type ReservingClient interface {
+	Reserve(logger lager.Logger, work rep.Work, ttl time.Duration) (reservationID string, rejectedWork rep.Work, err error)
+	Confirm(logger lager.Logger, reservationID string) (failedWork rep.Work, err error)
+	Release(logger lager.Logger, reservationID string) error
+}
+
 type AuctionRecord struct {
 	Winner   string
 	Attempts int
+	Priority Priority
+
+	// JournalID identifies the auction in a BatchJournal; 0 when the auction
+	// was not journaled.
+	JournalID uint64
 
 	QueueTime    time.Time
 	WaitDuration time.Duration
@@ -91,34 +188,148 @@ func NewAuctionRecord(now time.Time) AuctionRecord {
 	return AuctionRecord{QueueTime: now}
 }
 
+// InstanceCaps limit how many instances of a process guid may be placed on a
+// single cell and in a single zone. Zero means no limit.
+type InstanceCaps struct {
+	PerCell int
+	PerZone int
+}
+
+// An InstanceCapper assigns instance caps to incoming LRP start requests.
+type InstanceCapper interface {
+	InstanceCaps(lrp *rep.LRP) InstanceCaps
+}
+
+// AffinityRules relate an LRP to instances of other process guids on the same
+// cell. Required rules exclude cells that do not satisfy them, preferred rules
+// only make such cells score worse.
+type AffinityRules struct {
+	Required      []string // must run next to an instance of each
+	Preferred     []string // should run next to an instance of each
+	RequiredAnti  []string // must not run next to an instance of any
+	PreferredAnti []string // should not run next to an instance of any
+}
+
+// An AffinityAssigner assigns affinity rules to incoming LRP start requests.
+type AffinityAssigner interface {
+	AffinityRules(lrp *rep.LRP) AffinityRules
+}
+
+type SpreadPolicy string
+
+const (
+	SpreadBestEffort SpreadPolicy = "best-effort"
+	SpreadFail       SpreadPolicy = "fail"
+)
+
+// SpreadConstraint bounds the skew of an LRP's instances across the compatible
+// zones: a zone may get an instance as long as it then has at most MaxSkew
+// instances more than the least populated zone. When no such zone has room the
+// LRP fails under SpreadFail, and is placed in the least populated zone with
+// room otherwise. A MaxSkew of zero leaves the default zone balancing.
+type SpreadConstraint struct {
+	MaxSkew           int
+	WhenUnsatisfiable SpreadPolicy
+}
+
+// A SpreadAssigner assigns spread constraints to incoming LRP start requests.
+type SpreadAssigner interface {
+	SpreadConstraint(lrp *rep.LRP) SpreadConstraint
+}
+
+// Topology locates a cell around its zone, which is CellState.Zone: zones are
+// grouped in regions, and cells in racks and on physical hosts. Instances are
+// not spread across levels that are left empty.
+type Topology struct {
+	Region string
+	Rack   string
+	Host   string
+}
+
+// A TopologyResolver locates cells in the topology.
+type TopologyResolver interface {
+	CellTopology(cellID string, state rep.CellState) Topology
+}
+
 type LRPAuction struct {
 	rep.LRP
 	AuctionRecord
+	Caps     InstanceCaps
+	Affinity AffinityRules
+	Spread   SpreadConstraint
 }
 
 func NewLRPAuction(lrp rep.LRP, now time.Time) LRPAuction {
 	return LRPAuction{
 		lrp,
 		NewAuctionRecord(now),
+		InstanceCaps{},
+		AffinityRules{},
+		SpreadConstraint{},
 	}
 }
 
 func (a *LRPAuction) Copy() LRPAuction {
-	return LRPAuction{a.LRP.Copy(), a.AuctionRecord}
+	return LRPAuction{a.LRP.Copy(), a.AuctionRecord, a.Caps, a.Affinity, a.Spread}
 }
 
+// TaskAuction is the auction of a single task. Tasks that share a non-empty
+// Group are placed all together or not at all.
 type TaskAuction struct {
 	rep.Task
 	AuctionRecord
+	Group string
 }
 
 func NewTaskAuction(task rep.Task, now time.Time) TaskAuction {
 	return TaskAuction{
 		task,
 		NewAuctionRecord(now),
+		"",
 	}
 }
 
 func (a *TaskAuction) Copy() TaskAuction {
-	return TaskAuction{a.Task.Copy(), a.AuctionRecord}
+	return TaskAuction{a.Task.Copy(), a.AuctionRecord, a.Group}
+}
+
+// Scheduling Explanations
+
+type FilterStage string
+
+const (
+	FilterStageRootFS        FilterStage = "rootfs"
+	FilterStageVolumeDrivers FilterStage = "volume-drivers"
+	FilterStagePlacementTags FilterStage = "placement-tags"
+	FilterStageInstanceCaps  FilterStage = "instance-caps"
+	FilterStageAffinity      FilterStage = "affinity"
+	FilterStageSpread        FilterStage = "spread"
+	FilterStageZoneBalance   FilterStage = "zone-balance"
+	FilterStageResources     FilterStage = "resources"
+	FilterStageInflightLimit FilterStage = "inflight-limit"
+)
+
+// CellExplanation describes how the scheduler treated one cell for one
+// auction. RejectedBy is empty when the cell was scored.
+type CellExplanation struct {
+	CellID     string
+	Zone       string
+	RejectedBy FilterStage
+	Reason     string
+	Score      float64
+}
+
+type LRPExplanation struct {
+	LRPAuction
+	Cells []CellExplanation
+}
+
+type TaskExplanation struct {
+	TaskAuction
+	Cells []CellExplanation
+}
+
+type AuctionExplanation struct {
+	LRPs  []LRPExplanation
+	Tasks []TaskExplanation
 }
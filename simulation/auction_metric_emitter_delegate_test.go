@@ -19,3 +19,7 @@ func (_ auctionMetricEmitterDelegate) FetchStatesCompleted(_ time.Duration) erro
 func (_ auctionMetricEmitterDelegate) FailedCellStateRequest() {}
 
 func (_ auctionMetricEmitterDelegate) AuctionCompleted(_ auctiontypes.AuctionResults) {}
+
+func (_ auctionMetricEmitterDelegate) QueueDepth(_ int) {}
+
+func (_ auctionMetricEmitterDelegate) FetchCellRepsFailures(_ int) {}
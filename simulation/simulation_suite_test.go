@@ -99,6 +99,15 @@ var _ = BeforeEach(func() {
 
 	util.ResetGuids()
 
+	startRunner()
+})
+
+var _ = AfterEach(func() {
+	stopRunner()
+	workPool.Stop()
+})
+
+func startRunner(options ...auctionrunner.Option) {
 	runnerDelegate = NewAuctionRunnerDelegate(cells)
 	metricEmitterDelegate := NewAuctionMetricEmitterDelegate()
 	runner = auctionrunner.New(
@@ -109,15 +118,15 @@ var _ = BeforeEach(func() {
 		workPool,
 		0.25,
 		defaultMaxContainerStartCount,
+		options...,
 	)
 	runnerProcess = ifrit.Invoke(runner)
-})
+}
 
-var _ = AfterEach(func() {
+func stopRunner() {
 	runnerProcess.Signal(os.Interrupt)
 	Eventually(runnerProcess.Wait(), 20).Should(Receive())
-	workPool.Stop()
-})
+}
 
 var _ = AfterSuite(func() {
 	if !disableSVGReport {
@@ -145,7 +154,7 @@ func buildInProcessReps() map[string]rep.SimClient {
 }
 
 func startReport() {
-	svgReport = visualization.StartSVGReport("./"+reportName+".svg", 4, 3, numCells)
+	svgReport = visualization.StartSVGReport("./"+reportName+".svg", 4, 5, numCells)
 }
 
 func finishReport() {
@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"code.cloudfoundry.org/auction/auctionrunner"
 	"code.cloudfoundry.org/auction/simulation/util"
 	"code.cloudfoundry.org/auction/simulation/visualization"
 	"code.cloudfoundry.org/auctioneer"
@@ -68,7 +69,7 @@ var _ = Describe("Auction", func() {
 
 	runStartAuction := func(lrpStartAuctions []auctioneer.LRPStartRequest, numCells int) {
 		runnerDelegate.SetCellLimit(numCells)
-		runner.ScheduleLRPsForAuctions(lrpStartAuctions)
+		Expect(runner.ScheduleLRPsForAuctions(lrpStartAuctions)).To(Succeed())
 
 		Eventually(runnerDelegate.ResultSize, time.Minute, 100*time.Millisecond).Should(Equal(len(lrpStartAuctions)))
 	}
@@ -257,6 +258,56 @@ var _ = Describe("Auction", func() {
 			}
 		})
 
+		Context("Packing vs spreading", func() {
+			ncells := 10
+			napps := 100
+
+			Context("when spreading", func() {
+				It("should use every cell", func() {
+					instances := generateUniqueLRPStartAuctions(napps, 1)
+
+					report := runAndReportStartAuction(instances, ncells, 0, 4)
+
+					Expect(report.NCellsInUse()).To(Equal(ncells))
+				})
+			})
+
+			Context("when bin packing", func() {
+				BeforeEach(func() {
+					stopRunner()
+					startRunner(auctionrunner.WithSchedulerOptions(auctionrunner.WithScorer(auctionrunner.NewBinPackScorer())))
+				})
+
+				It("should leave cells empty", func() {
+					instances := generateUniqueLRPStartAuctions(napps, 1)
+
+					report := runAndReportStartAuction(instances, ncells, 1, 4)
+
+					Expect(report.NMissingInstances()).To(BeZero())
+					Expect(report.NCellsInUse()).To(BeNumerically("<", ncells))
+				})
+			})
+		})
+
+		Context("Committing in two phases", func() {
+			ncells := 10
+			napps := 100
+
+			BeforeEach(func() {
+				stopRunner()
+				startRunner(auctionrunner.WithSchedulerOptions(auctionrunner.WithTwoPhaseCommit(time.Minute)))
+			})
+
+			It("should place every instance", func() {
+				instances := generateUniqueLRPStartAuctions(napps, 1)
+
+				report := runAndReportStartAuction(instances, ncells, 2, 4)
+
+				Expect(report.NMissingInstances()).To(BeZero())
+				Expect(report.NCellsInUse()).To(Equal(ncells))
+			})
+		})
+
 		Context("Packing optimally when memory is low", func() {
 			nCells := 1
 
@@ -1,10 +1,12 @@
 package simulationrep
 
 import (
+	"fmt"
 	"net/http"
 	"sync"
 	"time"
 
+	"code.cloudfoundry.org/auction/auctiontypes"
 	"code.cloudfoundry.org/bbs/models"
 	"code.cloudfoundry.org/lager"
 	"code.cloudfoundry.org/rep"
@@ -19,10 +21,17 @@ type SimulationRep struct {
 	tasks                  map[string]rep.Task
 	startingContainerCount int
 	volumeDrivers          []string
+	reservations           map[string]reservation
+	nextReservation        int
 
 	lock *sync.Mutex
 }
 
+type reservation struct {
+	work    rep.Work
+	expires time.Time
+}
+
 func New(cellID string, stack string, zone string, totalResources rep.Resources, volumeDrivers []string) rep.SimClient {
 	return &SimulationRep{
 		cellID:         cellID,
@@ -30,6 +39,7 @@ func New(cellID string, stack string, zone string, totalResources rep.Resources,
 		totalResources: totalResources,
 		lrps:           map[string]rep.LRP{},
 		tasks:          map[string]rep.Task{},
+		reservations:   map[string]reservation{},
 		startingContainerCount: 0,
 		zone:          zone,
 		volumeDrivers: volumeDrivers,
@@ -75,49 +85,96 @@ func (r *SimulationRep) Perform(_ lager.Logger, work rep.Work) (rep.Work, error)
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
+	return r.perform(work), nil
+}
+
+func (r *SimulationRep) perform(work rep.Work) rep.Work {
 	failedWork := rep.Work{}
 
 	availableResources := r.availableResources()
 
 	for _, start := range work.LRPs {
-		hasRoom := availableResources.Containers >= 0
-		hasRoom = hasRoom && availableResources.MemoryMB >= start.MemoryMB
-		hasRoom = hasRoom && availableResources.DiskMB >= start.DiskMB
-
-		if hasRoom {
+		if hasRoom(availableResources, start.Resource) {
 			r.lrps[start.Identifier()] = start
 
-			availableResources.Containers -= 1
+			take(&availableResources, start.Resource)
 			if start.Domain == "auction" {
 				r.startingContainerCount++
 			}
-			availableResources.MemoryMB -= start.MemoryMB
-			availableResources.DiskMB -= start.DiskMB
 		} else {
 			failedWork.LRPs = append(failedWork.LRPs, start)
 		}
 	}
 
 	for _, task := range work.Tasks {
-		hasRoom := availableResources.Containers >= 0
-		hasRoom = hasRoom && availableResources.MemoryMB >= task.MemoryMB
-		hasRoom = hasRoom && availableResources.DiskMB >= task.DiskMB
-
-		if hasRoom {
+		if hasRoom(availableResources, task.Resource) {
 			r.tasks[task.TaskGuid] = task
 
-			availableResources.Containers -= 1
+			take(&availableResources, task.Resource)
 			if task.Domain == "auction" {
 				r.startingContainerCount++
 			}
-			availableResources.MemoryMB -= task.MemoryMB
-			availableResources.DiskMB -= task.DiskMB
 		} else {
 			failedWork.Tasks = append(failedWork.Tasks, task)
 		}
 	}
 
-	return failedWork, nil
+	return failedWork
+}
+
+func (r *SimulationRep) Reserve(_ lager.Logger, work rep.Work, ttl time.Duration) (string, rep.Work, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	heldWork := rep.Work{}
+	failedWork := rep.Work{}
+
+	availableResources := r.availableResources()
+
+	for _, start := range work.LRPs {
+		if hasRoom(availableResources, start.Resource) {
+			heldWork.LRPs = append(heldWork.LRPs, start)
+			take(&availableResources, start.Resource)
+		} else {
+			failedWork.LRPs = append(failedWork.LRPs, start)
+		}
+	}
+
+	for _, task := range work.Tasks {
+		if hasRoom(availableResources, task.Resource) {
+			heldWork.Tasks = append(heldWork.Tasks, task)
+			take(&availableResources, task.Resource)
+		} else {
+			failedWork.Tasks = append(failedWork.Tasks, task)
+		}
+	}
+
+	r.nextReservation++
+	reservationID := fmt.Sprintf("%s-%d", r.cellID, r.nextReservation)
+	r.reservations[reservationID] = reservation{work: heldWork, expires: time.Now().Add(ttl)}
+	return reservationID, failedWork, nil
+}
+
+func (r *SimulationRep) Confirm(_ lager.Logger, reservationID string) (rep.Work, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	held, ok := r.reservations[reservationID]
+	if !ok || time.Now().After(held.expires) {
+		delete(r.reservations, reservationID)
+		return rep.Work{}, auctiontypes.ErrorReservationExpired
+	}
+
+	delete(r.reservations, reservationID)
+	return r.perform(held.work), nil
+}
+
+func (r *SimulationRep) Release(_ lager.Logger, reservationID string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.reservations, reservationID)
+	return nil
 }
 
 //simulation only
@@ -128,6 +185,7 @@ func (r *SimulationRep) Reset() error {
 
 	r.lrps = map[string]rep.LRP{}
 	r.tasks = map[string]rep.Task{}
+	r.reservations = map[string]reservation{}
 	r.startingContainerCount = 0
 	return nil
 }
@@ -164,5 +222,27 @@ func (rep *SimulationRep) availableResources() rep.Resources {
 		resources.DiskMB -= task.DiskMB
 		resources.Containers -= 1
 	}
+	for id, held := range rep.reservations {
+		if time.Now().After(held.expires) {
+			delete(rep.reservations, id)
+			continue
+		}
+		for _, lrp := range held.work.LRPs {
+			take(&resources, lrp.Resource)
+		}
+		for _, task := range held.work.Tasks {
+			take(&resources, task.Resource)
+		}
+	}
 	return resources
 }
+
+func hasRoom(available rep.Resources, resource rep.Resource) bool {
+	return available.Containers >= 0 && available.MemoryMB >= resource.MemoryMB && available.DiskMB >= resource.DiskMB
+}
+
+func take(available *rep.Resources, resource rep.Resource) {
+	available.Containers -= 1
+	available.MemoryMB -= resource.MemoryMB
+	available.DiskMB -= resource.DiskMB
+}
@@ -71,6 +71,16 @@ func (r *Report) NReps() int {
 	return len(r.Cells)
 }
 
+func (r *Report) NCellsInUse() int {
+	inUse := 0
+	for _, instances := range r.InstancesByRep {
+		if len(instances) > 0 {
+			inUse++
+		}
+	}
+	return inUse
+}
+
 func (r *Report) NMissingInstances() int {
 	return r.NumAuctions - len(r.AuctionResults.SuccessfulLRPs)
 }
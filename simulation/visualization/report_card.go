@@ -167,6 +167,7 @@ func (r *SVGReport) drawText(report *Report, y int) {
 		fmt.Sprintf("%d over %d Reps %s", report.NumAuctions, report.NReps(), missing),
 		fmt.Sprintf("%.2fs (%.2f a/s)", report.AuctionDuration.Seconds(), report.AuctionsPerSecond()),
 		fmt.Sprintf("Dist: %.3f => %.3f", report.InitialDistributionScore(), report.DistributionScore()),
+		fmt.Sprintf("Cells in use: %d/%d", report.NCellsInUse(), report.NReps()),
 	}
 	statLines := []string{
 		"Wait Times",
@@ -177,7 +178,7 @@ func (r *SVGReport) drawText(report *Report, y int) {
 	r.SVG.Translate(border*2+instanceBoxWidth, y)
 	r.SVG.Gstyle("font-family:Helvetica Neue")
 	r.SVG.Textlines(8, 8, lines, 16, 18, "#333", "start")
-	r.SVG.Textlines(8, 80, statLines, 13, 16, "#333", "start")
+	r.SVG.Textlines(8, 98, statLines, 13, 16, "#333", "start")
 	r.SVG.Gend()
 	r.SVG.Gend()
 }